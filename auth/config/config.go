@@ -1,17 +1,15 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/spf13/viper"
+)
 
 type Config struct {
 	Port     string `mapstructure:"port"`
-	Keycloak struct {
-		Realm        string `mapstructure:"realm"`
-		URL          string `mapstructure:"url"`
-		ClientID     string `mapstructure:"client_id"`
-		ClientSecret string `mapstructure:"client_secret"` // Not asking for redirect URIs since we are not using oauth2
-		RedirectURI  string `mapstructure:"redirect_uri"`
-		Secret       string `mapstructure:"secret"`
-	}
+	Keycloak KeycloakConfig `mapstructure:"keycloak"`
 	AuthProviders AuthProviders `mapstructure:"auth_providers"`
 	Database struct {
 		Host     string `mapstructure:"host"`
@@ -22,7 +20,78 @@ type Config struct {
 	}
 	EmailService EmailServiceConfig `mapstructure:"email_service"`
 	Cookies CookiesConfig `mapstructure:"cookies"`
-	JWTSecretKey string `mapstructure:"jwt_secret_key"`
+	JWT JWTConfig `mapstructure:"jwt"`
+	Admin AdminConfig `mapstructure:"admin"`
+	VerificationCode VerificationCodeConfig `mapstructure:"verification_code"`
+	MagicLink MagicLinkConfig `mapstructure:"magic_link"`
+	MailOutbox MailOutboxConfig `mapstructure:"mail_outbox"`
+	AccountLinking AccountLinkingConfig `mapstructure:"account_linking"`
+	ReturnTo ReturnToConfig `mapstructure:"return_to"`
+	OAuthServer OAuthServerConfig `mapstructure:"oauth_server"`
+	PersonalAccessTokens PersonalAccessTokensConfig `mapstructure:"personal_access_tokens"`
+	TokenPepper string `mapstructure:"token_pepper"` // secret keying the hashes of the refresh tokens and verification codes
+}
+
+// KeycloakConfig describes the Keycloak realm users can log in with, at /auth/keycloak.
+// RedirectURI is where users land once logged in, with any provider.
+type KeycloakConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Realm        string        `mapstructure:"realm"`
+	URL          string        `mapstructure:"url"`
+	ClientID     string        `mapstructure:"client_id"`
+	ClientSecret string        `mapstructure:"client_secret"`
+	RedirectURI  string        `mapstructure:"redirect_uri"`
+	CallbackURI  string        `mapstructure:"callback_uri"` // our /auth/keycloak/callback endpoint, as registered in the Keycloak client
+	Secret       string        `mapstructure:"secret"`
+	Scopes       []string      `mapstructure:"scopes"`
+	Roles        []RoleMapping `mapstructure:"roles"`
+}
+
+// RoleMapping gives our role To to the users having the Keycloak group or role From,
+// written group:<path>, realm:<role> or client:<role> for the roles of our client
+type RoleMapping struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// ReturnToConfig tells where users may land once logged in: return_to must be under one of the Allowed URLs,
+// and defaults to Default, itself defaulting to keycloak.redirect_uri
+type ReturnToConfig struct {
+	Default string   `mapstructure:"default"`
+	Allowed []string `mapstructure:"allowed"`
+}
+
+// OAuthServerConfig sets up the auth service as the OAuth 2.1 / OpenID Connect provider of our own apps
+type OAuthServerConfig struct {
+	Issuer   string        `mapstructure:"issuer"`    // public URL of the auth service, the endpoints of the discovery document are under it
+	LoginURL string        `mapstructure:"login_url"` // where users log in first, coming back to the authorization request through return_to
+	CodeTTL  time.Duration `mapstructure:"code_ttl"`  // how long a client has to exchange an authorization code
+	// lifetime of the access tokens issued to services through client credentials, which ask for a new one when it expires
+	ServiceTokenTTL time.Duration `mapstructure:"service_token_ttl"`
+}
+
+// PersonalAccessTokensConfig limits the tokens users mint for their scripts
+type PersonalAccessTokensConfig struct {
+	Scopes     []string      `mapstructure:"scopes"`      // the scopes users can give their tokens
+	DefaultTTL time.Duration `mapstructure:"default_ttl"` // lifetime of the tokens minted without an expiration date
+	MaxTTL     time.Duration `mapstructure:"max_ttl"`
+}
+
+type AccountLinkingConfig struct {
+	// links a new identity to the existing user with the same email, when the provider verified it
+	AutoLink bool `mapstructure:"auto_link"`
+}
+
+type JWTConfig struct {
+	Algorithm  string   `mapstructure:"algorithm"`   // RS256 or EdDSA, used when generating a key
+	PrivateKey string   `mapstructure:"private_key"` // PEM encoded PKCS#8 private key, seeds an empty keyring
+	KeysDir    string   `mapstructure:"keys_dir"`    // where the signing keyring is stored
+	Issuer     string   `mapstructure:"issuer"`      // of the access tokens, which other services check
+	Audience   []string `mapstructure:"audience"`    // the services the access tokens are meant for
+}
+
+type AdminConfig struct {
+	Token string `mapstructure:"token"` // bearer token required by the admin endpoints, which are disabled if empty
 }
 
 type CookiesConfig struct {
@@ -33,24 +102,62 @@ type CookiesConfig struct {
 		Value   string `mapstructure:"value"`
 	} `mapstructure:"domain"`
 	Auth struct {
-		Enabled bool `mapstructure:"enabled"`
-		AccessToken string `mapstructure:"access_token"`
+		verifier.Cookies `mapstructure:",squash"` // enabled and access_token, read the same way by the other services
 		RefreshToken string `mapstructure:"refresh_token"`
 	}
 }
 
-type AuthProviders []struct {
-	Name         string `mapstructure:"name"`
-	ClientID     string `mapstructure:"client_id"`
-	ClientSecret string `mapstructure:"client_secret"`
-	RedirectURI  string `mapstructure:"redirect_uri"`
+type VerificationCodeConfig struct {
+	Length      int           `mapstructure:"length"`
+	Alphabet    string        `mapstructure:"alphabet"`     // digits or crockford
+	TTL         time.Duration `mapstructure:"ttl"`          // how long a code can be used, e.g. "10m"
+	MaxAttempts int           `mapstructure:"max_attempts"` // failed attempts after which a code is burnt
+	Cooldown    time.Duration `mapstructure:"cooldown"`     // minimum delay between two codes sent to the same email
+}
+
+type MagicLinkConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	URL     string        `mapstructure:"url"` // public URL of the auth service, the links point to
+	TTL     time.Duration `mapstructure:"ttl"`
+}
+
+type AuthProviders []AuthProvider
+
+// AuthProvider is either a built-in provider, github or google, picked by its name,
+// or any OpenID Connect provider when its type is oidc, its endpoints being discovered from its issuer
+type AuthProvider struct {
+	Name         string   `mapstructure:"name"`
+	Type         string   `mapstructure:"type"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURI  string   `mapstructure:"redirect_uri"`
+	Issuer       string   `mapstructure:"issuer"`
+	Scopes       []string `mapstructure:"scopes"`
 }
 
 type EmailServiceConfig struct {
-	Host     string `mapstructure:"host"`
-	Port     int    `mapstructure:"port"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
+	Transport     string `mapstructure:"transport"` // smtp, file or memory
+	Host          string `mapstructure:"host"`
+	Port          int    `mapstructure:"port"`
+	User          string `mapstructure:"user"`
+	Password      string `mapstructure:"password"`
+	TLS           string `mapstructure:"tls"`       // starttls, tls or none
+	Directory     string `mapstructure:"directory"` // where the file transport writes the emails
+	From          string `mapstructure:"from"`
+	TemplatesDir  string `mapstructure:"templates_dir"`
+	DefaultLocale string `mapstructure:"default_locale"`
+}
+
+// MailOutboxConfig tunes the workers delivering the queued emails.
+// A failed email is retried after BaseDelay, the delay doubling after each attempt up to MaxDelay.
+type MailOutboxConfig struct {
+	Workers      int           `mapstructure:"workers"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	Lease        time.Duration `mapstructure:"lease"` // how long a worker has to send an email before another one may claim it
+	MaxAttempts  int           `mapstructure:"max_attempts"`
+	BaseDelay    time.Duration `mapstructure:"base_delay"`
+	MaxDelay     time.Duration `mapstructure:"max_delay"`
 }
 
 func LoadConfig() {
@@ -58,6 +165,31 @@ func LoadConfig() {
 	viper.AddConfigPath(".")
 	viper.SetConfigType("json")
 	viper.AutomaticEnv()
+	viper.SetDefault("verification_code.length", 6)
+	viper.SetDefault("verification_code.alphabet", "digits")
+	viper.SetDefault("verification_code.ttl", "10m")
+	viper.SetDefault("verification_code.max_attempts", 5)
+	viper.SetDefault("verification_code.cooldown", "1m")
+	viper.SetDefault("magic_link.ttl", "5m")
+	viper.SetDefault("email_service.transport", "smtp")
+	viper.SetDefault("email_service.tls", "starttls")
+	viper.SetDefault("email_service.directory", "mails")
+	viper.SetDefault("email_service.templates_dir", "templates/email")
+	viper.SetDefault("email_service.default_locale", "en")
+	viper.SetDefault("mail_outbox.workers", 2)
+	viper.SetDefault("mail_outbox.batch_size", 10)
+	viper.SetDefault("mail_outbox.poll_interval", "5s")
+	viper.SetDefault("mail_outbox.lease", "1m")
+	viper.SetDefault("mail_outbox.max_attempts", 8)
+	viper.SetDefault("mail_outbox.base_delay", "10s")
+	viper.SetDefault("mail_outbox.max_delay", "1h")
+	viper.SetDefault("jwt.issuer", "gists")
+	viper.SetDefault("jwt.audience", []string{"gists"})
+	viper.SetDefault("oauth_server.code_ttl", "1m")
+	viper.SetDefault("oauth_server.service_token_ttl", "5m")
+	viper.SetDefault("personal_access_tokens.scopes", []string{"gists:read", "gists:write"})
+	viper.SetDefault("personal_access_tokens.default_ttl", "720h")
+	viper.SetDefault("personal_access_tokens.max_ttl", "8760h")
 	err := viper.ReadInConfig()
 
 	if err != nil {
@@ -71,5 +203,8 @@ func GetConfig() Config {
 	if err != nil {
 		panic("Error unmarshalling config : " + err.Error())
 	}
+	if config.ReturnTo.Default == "" {
+		config.ReturnTo.Default = config.Keycloak.RedirectURI
+	}
 	return config
 }
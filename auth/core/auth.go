@@ -1,34 +1,46 @@
 package core
 
 import (
+	"context"
+	"crypto/hmac"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gistsapp/api/auth/config"
 	"github.com/gistsapp/api/auth/repositories"
 	"github.com/gistsapp/api/auth/utils"
 	"github.com/gistsapp/api/types"
-	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/markbates/goth"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/google"
-	"github.com/shareed2k/goth_fiber"
 )
 
 type AuthService interface {
-	RegisterProviders()                                                  //done
-	IsAuthenticated(token string) (*types.JWTClaims, error)              //done
-	Renew(token string) (*types.AuthTokens, error)                       //done
-	AuthenticateWithRedirect(c *fiber.Ctx) error                         //done
-	AuthenticateWithCode(email string) (*types.VerificationToken, error) //done
-	VerifyAuthToken(code string, email string) (*types.AuthTokens, error)
-	Callback(c *fiber.Ctx) (*types.AuthTokens, error)               //done
-	RegisterUser(options *RegistrationOptions) (*types.User, error) //done
+	RegisterProviders()                                                       //done
+	IsAuthenticated(token string) (*types.JWTClaims, error)                   //done
+	Renew(refresh_token string, client ClientInfo) (*types.AuthTokens, error) //done
+	BeginRedirect(provider string, return_to string) (string, string, error)
+	VerifyAuthFlow(signed_flow string, provider string, state string) (*AuthFlow, error)
+	AuthenticateWithCode(email string, nonce string, language string) (*types.VerificationToken, error) //done
+	VerifyAuthToken(code string, email string, client ClientInfo) (*types.AuthTokens, error)
+	VerifyMagicLink(link_token string, nonce string, client ClientInfo) (*types.AuthTokens, error)
+	Callback(flow *AuthFlow, code string, client ClientInfo) (*types.AuthTokens, error) //done
+	RegisterUser(options *RegistrationOptions) (*types.User, error)                     //done
 	Introspect(token string) (*types.User, *types.FederatedIdentity, *types.JWTClaims, error)
+	Logout(access_token string, refresh_token string) error
+	LogoutEverywhere(user_id string) error
+	Sessions(user_id string) ([]*types.Session, error)
+	RevokeSession(user_id string, session_id string) error
+	BackchannelLogout(logout_token string) error
+	LinkIntent(user_id string, provider string) (string, error)
+	Link(flow *AuthFlow, code string, link_intent string) (*types.FederatedIdentity, error)
+	Identities(user_id string) ([]*types.FederatedIdentity, error)
+	Unlink(user_id string, federated_identity_id string) error
 }
 
 type RegistrationOptions struct {
@@ -37,40 +49,70 @@ type RegistrationOptions struct {
 }
 
 type authService struct {
-	providers    []goth.Provider
-	jwtService   JWTService
-	userService  UserService
-	database     repositories.Database
-	emailService repositories.EmailService
+	providers       map[string]RedirectProvider
+	jwtService      JWTService
+	userService     UserService
+	database        repositories.Database
+	emailService    repositories.EmailService
+	hasher          TokenHasher
+	codes           *utils.CodeGenerator
+	codeConfig      config.VerificationCodeConfig
+	magicLinkConfig config.MagicLinkConfig
+	keycloak        *Keycloak // nil when Keycloak isn't enabled
+	linkingConfig   config.AccountLinkingConfig
+	returnToConfig  config.ReturnToConfig
 }
 
-func NewAuthService(providers_config config.AuthProviders, jwtService JWTService, userService UserService, database repositories.Database, emailService repositories.EmailService) AuthService {
-	providers := []goth.Provider{}
+// NewAuthService sets up the providers of the config, along with Keycloak when it is given.
+// It fails when an OpenID Connect provider can't be discovered, rather than running without it.
+func NewAuthService(providers_config config.AuthProviders, keycloak *Keycloak, jwtService JWTService, userService UserService, database repositories.Database, emailService repositories.EmailService, hasher TokenHasher, codes *utils.CodeGenerator, codeConfig config.VerificationCodeConfig, magicLinkConfig config.MagicLinkConfig, linkingConfig config.AccountLinkingConfig, returnToConfig config.ReturnToConfig) (AuthService, error) {
+	providers := map[string]RedirectProvider{}
 
 	for _, provider := range providers_config {
+		if provider.Type == "oidc" {
+			oidc_provider, err := NewOIDCProvider(context.Background(), provider)
+			if err != nil {
+				return nil, fmt.Errorf("can't set up the %s provider: %w", provider.Name, err)
+			}
+			providers[provider.Name] = oidc_provider
+			continue
+		}
 		switch provider.Name {
 		case "github":
-			provider := github.New(provider.ClientID, provider.ClientSecret, provider.RedirectURI)
-			providers = append(providers, provider)
+			providers[provider.Name] = newGithubProvider(provider)
 			break
 		case "google":
-			provider := google.New(provider.ClientID, provider.ClientSecret, provider.RedirectURI)
-			providers = append(providers, provider)
+			providers[provider.Name] = newGoogleProvider(provider)
 			break
+		default:
+			log.Warn("Unknown provider ", provider.Name, ", set its type to oidc if it is an OpenID Connect provider")
 		}
 	}
 
-	return &authService{
-		providers:    providers,
-		jwtService:   jwtService,
-		userService:  userService,
-		database:     database,
-		emailService: emailService,
+	if keycloak != nil {
+		providers[KeycloakProvider] = keycloak.Provider()
 	}
+
+	return &authService{
+		providers:       providers,
+		jwtService:      jwtService,
+		userService:     userService,
+		database:        database,
+		emailService:    emailService,
+		hasher:          hasher,
+		codes:           codes,
+		codeConfig:      codeConfig,
+		magicLinkConfig: magicLinkConfig,
+		keycloak:        keycloak,
+		linkingConfig:   linkingConfig,
+		returnToConfig:  returnToConfig,
+	}, nil
 }
 
 func (a *authService) RegisterProviders() {
-	goth.UseProviders(a.providers...)
+	for _, provider := range a.providers {
+		goth.UseProviders(provider)
+	}
 }
 
 func (a *authService) IsAuthenticated(token string) (*types.JWTClaims, error) {
@@ -83,52 +125,98 @@ func (a *authService) IsAuthenticated(token string) (*types.JWTClaims, error) {
 	return claims, nil
 }
 
-func (a *authService) Renew(token string) (*types.AuthTokens, error) {
-	claims, err := a.jwtService.VerifyAccessToken(token)
-
-	if err != jwt.ErrTokenExpired {
+// Renew exchanges a refresh token for a new pair of tokens, the refresh token being consumed in the process
+func (a *authService) Renew(refresh_token string, client ClientInfo) (*types.AuthTokens, error) {
+	rotated_token, err := a.jwtService.RotateRefreshToken(refresh_token, "", client)
+	if err != nil {
 		return nil, err
 	}
 
-
-	user, err := a.userService.GetUserByID(claims.UserID)
+	user, err := a.userService.GetUserByID(rotated_token.UserID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return a.generateTokens(user)
-}
-
-func (a *authService) Callback(c *fiber.Ctx) (*types.AuthTokens, error) {
-	provider := c.Params("provider")
+	// sessions opened before tokens remembered their identity fall back to the first identity of the user
+	identity_id := ""
+	if rotated_token.FederatedIdentityID != nil {
+		identity_id = *rotated_token.FederatedIdentityID
+	} else {
+		identity, err := a.database.GetFederatedIdentityByUserID(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		identity_id = identity.ID
+	}
 
-	if provider != "github" && provider != "google" {
-		return nil, UnkownProvider
+	access_token, err := a.createAccessToken(user, identity_id, rotated_token.FamilyID)
+	if err != nil {
+		return nil, err
 	}
+	return &types.AuthTokens{
+		AccessToken:  access_token,
+		RefreshToken: rotated_token.Token,
+	}, nil
+}
 
-	auth_user, err := goth_fiber.CompleteUserAuth(c)
+// Callback completes the redirect login of the flow, verified beforehand with VerifyAuthFlow, with the code the provider gave back
+func (a *authService) Callback(flow *AuthFlow, code string, client ClientInfo) (*types.AuthTokens, error) {
+	provider := flow.Provider
+
+	auth_user, err := a.completeRedirect(flow, code)
 
 	if err != nil {
+		log.Warnw("Couldn't complete the login with the provider", "provider", provider, "error", err)
 		return nil, ErrCantCompleteAuth
 	}
 
-	user, err := a.userService.GetUserByID(auth_user.UserID)
+	// the provider's user id, namespaced by the provider, is the id of the federated identity, users being found through it
+	user, err := a.userService.GetUserThroughFederatedIdentity(auth_user.UserID)
 
 	if err == types.ErrNotFound { // user not found, create user
 		user, err = a.firstLogin(auth_user, provider)
 		if err != nil {
 			return nil, err
 		}
-		return a.generateTokens(user)
-	} else {
-		return a.generateTokens(user)
+	} else if err != nil {
+		return nil, err
+	}
+	if provider == KeycloakProvider && a.keycloak != nil {
+		// the roles follow the ones granted in Keycloak, as of this login
+		if err := a.database.SetUserRoles(user.ID, a.keycloak.Roles(auth_user)); err != nil {
+			return nil, err
+		}
 	}
+	return a.generateTokens(user, auth_user.UserID, client)
 }
 
+// BackchannelLogout ends every session of the user Keycloak says logged out
+func (a *authService) BackchannelLogout(logout_token string) error {
+	if a.keycloak == nil {
+		return UnkownProvider
+	}
+	subject, err := a.keycloak.VerifyLogoutToken(logout_token)
+	if err != nil {
+		log.Warnw("Security event: rejected a back-channel logout token", "error", err)
+		return ErrInvalidLogoutToken
+	}
+	user, err := a.database.GetUserThroughFederatedIdentity(identityID(KeycloakProvider, subject))
+	if err == sql.ErrNoRows { // never logged in with Keycloak, nothing to end
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.jwtService.RevokeAllTokens(user.ID)
+}
+
+// firstLogin registers the user of a new identity, unless the identity can be linked to an existing user
 func (a *authService) firstLogin(auth_user goth.User, provider string) (*types.User, error) {
-	var user_identity *types.User
-	var err error
+	user_identity, err := a.autoLink(auth_user)
+	if err != nil || user_identity != nil {
+		return user_identity, err
+	}
 	if provider == "local" {
 		user_identity, err = a.RegisterUser(withEmailPrefix(auth_user))
 	} else {
@@ -140,35 +228,42 @@ func (a *authService) firstLogin(auth_user goth.User, provider string) (*types.U
 	return user_identity, nil
 }
 
-func (a *authService) generateTokens(user *types.User) (*types.AuthTokens, error) {
-	log.Info(user)
-	identity, err := a.database.GetFederatedIdentityByUserID(user.ID)
-	log.Info(identity)
-	if err != nil {
-		return nil, err
-	}
-	claims := &types.JWTClaims{
-		UserID: user.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject: identity.ID,
-		},
-	}
-
-	access_token, err := a.jwtService.CreateAccessToken(claims)
+// generateTokens starts a new session for the user, who logged in with the identity identity_id
+func (a *authService) generateTokens(user *types.User, identity_id string, client ClientInfo) (*types.AuthTokens, error) {
+	refresh_token, err := a.jwtService.CreateRefreshToken(user.ID, identity_id, client)
 	if err != nil {
 		return nil, err
 	}
 
-	refresh_token, err := a.jwtService.CreateRefreshToken(user.ID)
+	access_token, err := a.createAccessToken(user, identity_id, refresh_token.FamilyID)
 	if err != nil {
 		return nil, err
 	}
 	return &types.AuthTokens{
 		AccessToken:  access_token,
-		RefreshToken: refresh_token,
+		RefreshToken: refresh_token.Token,
 	}, nil
 }
 
+// createAccessToken issues an access token tied to the session, so that it stops working once the session is revoked.
+// Its subject is the identity the session was opened with.
+func (a *authService) createAccessToken(user *types.User, identity_id string, session_id string) (string, error) {
+	roles, err := a.database.GetUserRoles(user.ID)
+	if err != nil {
+		return "", err
+	}
+	claims := &types.JWTClaims{
+		UserID:    user.ID,
+		SessionID: session_id,
+		Roles:     roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: identity_id,
+		},
+	}
+
+	return a.jwtService.CreateAccessToken(claims)
+}
+
 func withOIDCUsername(user goth.User) *RegistrationOptions {
 	return &RegistrationOptions{
 		GothUser: user,
@@ -215,54 +310,152 @@ func (a *authService) RegisterUser(options *RegistrationOptions) (*types.User, e
 	return user_data, err
 }
 
-func (a *authService) AuthenticateWithCode(email string) (*types.VerificationToken, error) {
+// AuthenticateWithCode sends a code to the email, along with a magic link if a browser nonce is given.
+// The email is written in the locale that best matches language, an Accept-Language header.
+func (a *authService) AuthenticateWithCode(email string, nonce string, language string) (*types.VerificationToken, error) {
 	log.Info("Authenticating ", email)
-	token_value := utils.GenToken(6)
-	token_data := types.VerificationToken{
-		Token: token_value,
-		Email: email,
+	now := time.Now()
+	// a new code replaces the previous one, but not more often than the cooldown allows, even when the previous one was burnt
+	err := a.database.StartVerificationCooldown(email, now, a.codeConfig.Cooldown)
+	if err == sql.ErrNoRows {
+		return nil, ErrCodeCooldown
 	}
-
-	token, err := a.database.CreateVerificationToken(&token_data)
-	if err != nil { // need to check if the token already exists
-		token, err = a.database.GetVerificationTokenByEmail(email)
-		if err != nil {
+	if err != nil {
+		return nil, err
+	}
+	previous, err := a.database.GetVerificationTokenByEmail(email)
+	if err == nil {
+		err = a.database.DeleteVerificationToken(email, previous.Token)
+		if err != nil && err != sql.ErrNoRows {
 			return nil, err
 		}
-		err = a.database.DeleteVerificationToken(email, token.Token)
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	token_value, err := a.codes.Generate()
+	if err != nil {
+		return nil, err
+	}
+	token_data := types.VerificationToken{
+		Token:     a.hasher.Hash(token_value), // only the hash is stored, the code itself is only sent by email
+		Email:     email,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(a.codeConfig.TTL).Format(time.RFC3339),
+	}
+
+	magic_link := ""
+	if a.magicLinkConfig.Enabled && nonce != "" {
+		link_token, err := utils.RandomToken(32)
 		if err != nil {
 			return nil, err
 		}
+		link_hash := a.hasher.Hash(link_token)
+		nonce_hash := a.hasher.Hash(nonce)
+		link_expires_at := now.Add(a.magicLinkConfig.TTL).Format(time.RFC3339)
+		token_data.LinkToken = &link_hash
+		token_data.LinkNonce = &nonce_hash
+		token_data.LinkExpiresAt = &link_expires_at
+		magic_link = strings.TrimSuffix(a.magicLinkConfig.URL, "/") + "/auth/local/magic?token=" + url.QueryEscape(link_token)
+	}
 
-		// retry
-		return a.AuthenticateWithCode(email)
+	token, err := a.database.CreateVerificationToken(&token_data)
+	if err != nil { // another request created a code in the meantime
+		return nil, ErrCodeCooldown
 	}
 
-	log.Info(token_value)
-	if err := a.emailService.SendVerificationEmail(email, token_value); err != nil {
+	if err := a.emailService.SendVerificationEmail(email, repositories.VerificationEmail{
+		Code:      token_value,
+		MagicLink: magic_link,
+		Language:  language,
+		ExpiresAt: now.Add(a.codeConfig.TTL),
+	}); err != nil {
 		return nil, ErrConfirmationEmail
 	}
 
 	return token, nil
 }
 
-func (a *authService) AuthenticateWithRedirect(c *fiber.Ctx) error {
-	if _, err := goth_fiber.CompleteUserAuth(c); err == nil {
-		return nil
-	} else {
-		return goth_fiber.BeginAuthHandler(c)
+func (a *authService) VerifyAuthToken(code string, email string, client ClientInfo) (*types.AuthTokens, error) {
+	token, err := a.database.GetVerificationTokenByEmail(email)
+	if err == sql.ErrNoRows {
+		return nil, ErrCantCompleteAuth
+	}
+	if err != nil {
+		return nil, err
 	}
+
+	expires_at, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expires_at) {
+		a.database.DeleteVerificationToken(email, token.Token)
+		return nil, ErrCodeExpired
+	}
+
+	// every attempt is counted before the code is compared, so that concurrent guesses can't get past the limit
+	attempts, err := a.database.IncrementVerificationTokenAttempts(email, token.Token, a.codeConfig.MaxAttempts)
+	if err == sql.ErrNoRows { // burnt, or replaced by another code
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(a.hasher.Hash(a.codes.Normalize(code))), []byte(token.Token)) {
+		// the code is burnt once too many attempts were made
+		if attempts >= a.codeConfig.MaxAttempts {
+			log.Warnw("Security event: too many attempts on a verification code, burning it", "email", email)
+			a.database.DeleteVerificationToken(email, token.Token)
+		}
+		return nil, ErrInvalidCode
+	}
+
+	return a.completeLocalAuth(token, client)
 }
 
-func (a *authService) VerifyAuthToken(code string, email string) (*types.AuthTokens, error) {
-	err := a.database.DeleteVerificationToken(email, code)
+// VerifyMagicLink completes the authentication started with AuthenticateWithCode, from the link sent by email.
+// The link only works in the browser that asked for it, which proves it by sending back its nonce.
+func (a *authService) VerifyMagicLink(link_token string, nonce string, client ClientInfo) (*types.AuthTokens, error) {
+	token, err := a.database.GetVerificationTokenByLink(a.hasher.Hash(link_token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expires_at, err := time.Parse(time.RFC3339, *token.LinkExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expires_at) {
+		return nil, ErrMagicLinkExpired
+	}
+
+	if nonce == "" || !hmac.Equal([]byte(a.hasher.Hash(nonce)), []byte(*token.LinkNonce)) {
+		log.Warnw("Security event: magic link opened from another browser than the one that asked for it", "email", token.Email)
+		return nil, ErrMagicLinkNonce
+	}
+
+	return a.completeLocalAuth(token, client)
+}
+
+// completeLocalAuth consumes the verification token, and logs in the user of its email, registering it on first login
+func (a *authService) completeLocalAuth(token *types.VerificationToken, client ClientInfo) (*types.AuthTokens, error) {
+	email := token.Email
+	// deleting the token is what makes it single use, only one concurrent request can succeed
+	err := a.database.DeleteVerificationToken(email, token.Token)
 	if err == sql.ErrNoRows {
 		return nil, ErrCantCompleteAuth
 	}
+	if err != nil {
+		return nil, err
+	}
 	//now we finish user registration
 
 	goth_user := goth.User{
-		UserID:    email,
+		UserID:    identityID("local", email),
 		Email:     email,
 		Provider:  "local",
 		AvatarURL: "https://vercel.com/api/www/avatar/?u=" + email + "&s=80",
@@ -272,7 +465,7 @@ func (a *authService) VerifyAuthToken(code string, email string) (*types.AuthTok
 	log.Info(err)
 	var user *types.User
 	if err == sql.ErrNoRows { // user not found, create user because first connection
-		user, err = a.RegisterUser(withEmailPrefix(goth_user))
+		user, err = a.firstLogin(goth_user, "local")
 	} else {
 		user, err = a.userService.GetUserByID(federated_identity.UserID)
 	}
@@ -280,7 +473,7 @@ func (a *authService) VerifyAuthToken(code string, email string) (*types.AuthTok
 		log.Error(err)
 		return nil, err
 	}
-	return a.generateTokens(user)
+	return a.generateTokens(user, goth_user.UserID, client)
 }
 
 func (a *authService) Introspect(token string) (*types.User, *types.FederatedIdentity, *types.JWTClaims, error) {
@@ -304,11 +497,68 @@ func (a *authService) Introspect(token string) (*types.User, *types.FederatedIde
 		log.Error("Couldn't get federated identity", err)
 		return nil, nil, nil, err
 	}
-	
+
 	return user, federated_identity, claims, nil
 }
 
+// Logout ends the current session: the refresh token family is revoked and the access token denylisted.
+// Any of the tokens can be empty, as clients don't always hold both.
+func (a *authService) Logout(access_token string, refresh_token string) error {
+	if refresh_token != "" {
+		err := a.jwtService.InvalidateRefreshToken(refresh_token)
+		if err != nil && err != ErrInvalidRefreshToken {
+			return err
+		}
+	}
+	if access_token != "" {
+		claims, err := a.jwtService.VerifyAccessToken(access_token)
+		if err != nil { // already unusable, nothing to revoke
+			return nil
+		}
+		return a.jwtService.RevokeAccessToken(claims)
+	}
+	return nil
+}
+
+// LogoutEverywhere ends every session of the user
+func (a *authService) LogoutEverywhere(user_id string) error {
+	return a.jwtService.RevokeAllTokens(user_id)
+}
+
+// Sessions lists the sessions of the user that can still be renewed
+func (a *authService) Sessions(user_id string) ([]*types.Session, error) {
+	tokens, err := a.database.GetActiveOpaqueTokensByUserID(user_id)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []*types.Session{}
+	for _, token := range tokens {
+		sessions = append(sessions, &types.Session{
+			ID:         token.FamilyID,
+			CreatedAt:  token.CreatedAt,
+			LastUsedAt: token.LastUsedAt,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the sessions of the user, its access tokens being rejected from now on
+func (a *authService) RevokeSession(user_id string, session_id string) error {
+	err := a.database.DeleteUserOpaqueTokenFamily(user_id, session_id)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
 var ErrCantCompleteAuth error = errors.New("Couldn't complete auth")
 var ErrInvalidCode error = errors.New("Invalid verification code")
+var ErrCodeExpired error = errors.New("Verification code expired")
+var ErrInvalidMagicLink error = errors.New("Invalid magic link")
+var ErrMagicLinkExpired error = errors.New("Magic link expired")
+var ErrMagicLinkNonce error = errors.New("Magic link must be opened in the browser it was requested from")
+var ErrCodeCooldown error = errors.New("A verification code was sent recently, please wait before asking for another one")
 var UnkownProvider error = errors.New("Unkown provider")
 var ErrConfirmationEmail error = errors.New("Confirmation email could not be sent")
@@ -0,0 +1,345 @@
+package core
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gistsapp/api/auth/utils"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// memoryDatabase keeps the users, their identities and their sessions in memory.
+// The other methods of repositories.Database aren't implemented, calling them fails the test with a panic.
+type memoryDatabase struct {
+	repositories.Database
+	users      map[string]*types.User
+	identities []*types.FederatedIdentity // in creation order
+	tokens     map[string]*types.OpaqueToken
+	codes      map[string]*types.VerificationToken // by email
+}
+
+func newMemoryDatabase() *memoryDatabase {
+	return &memoryDatabase{
+		users:  map[string]*types.User{},
+		tokens: map[string]*types.OpaqueToken{},
+		codes:  map[string]*types.VerificationToken{},
+	}
+}
+
+func (m *memoryDatabase) CreateUser(user *types.User) (*types.User, error) {
+	created := *user
+	created.ID = uuid.New().String()
+	m.users[created.ID] = &created
+	return &created, nil
+}
+
+func (m *memoryDatabase) GetUserByID(id string) (*types.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *memoryDatabase) GetUserThroughFederatedIdentity(federated_id string) (*types.User, error) {
+	for _, identity := range m.identities {
+		if identity.ID == federated_id {
+			return m.GetUserByID(identity.UserID)
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryDatabase) CreateFederatedIdentity(federated_identity *types.FederatedIdentity) (*types.FederatedIdentity, error) {
+	created := *federated_identity
+	m.identities = append(m.identities, &created)
+	return &created, nil
+}
+
+func (m *memoryDatabase) GetFederatedIdentityByUserID(id string) (*types.FederatedIdentity, error) {
+	for _, identity := range m.identities {
+		if identity.UserID == id {
+			return identity, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryDatabase) GetUserRoles(user_id string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (m *memoryDatabase) CreateOpaqueToken(opaque_token *types.OpaqueToken) (*types.OpaqueToken, error) {
+	stored := *opaque_token
+	m.tokens[stored.ID] = &stored
+	created := stored
+	return &created, nil
+}
+
+func (m *memoryDatabase) GetOpaqueTokenByToken(token string) (*types.OpaqueToken, error) {
+	for _, opaque_token := range m.tokens {
+		if opaque_token.Token == token {
+			found := *opaque_token
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryDatabase) RotateOpaqueToken(id string) error {
+	opaque_token, ok := m.tokens[id]
+	if !ok || opaque_token.RotatedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now().Format(time.RFC3339)
+	opaque_token.RotatedAt = &now
+	return nil
+}
+
+func (m *memoryDatabase) DeleteOpaqueTokenFamily(family_id string) error {
+	for id, opaque_token := range m.tokens {
+		if opaque_token.FamilyID == family_id {
+			delete(m.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryDatabase) IsAccessTokenRevoked(jti string, user_id string, session_id string, issued_at time.Time) (bool, error) {
+	return false, nil
+}
+
+func (m *memoryDatabase) GetVerificationTokenByEmail(email string) (*types.VerificationToken, error) {
+	token, ok := m.codes[email]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *token
+	return &found, nil
+}
+
+func (m *memoryDatabase) IncrementVerificationTokenAttempts(email string, value string, max_attempts int) (int, error) {
+	token, ok := m.codes[email]
+	if !ok || token.Token != value || token.Attempts >= max_attempts {
+		return 0, sql.ErrNoRows
+	}
+	token.Attempts++
+	return token.Attempts, nil
+}
+
+func (m *memoryDatabase) DeleteVerificationToken(email string, value string) error {
+	token, ok := m.codes[email]
+	if !ok || token.Token != value {
+		return sql.ErrNoRows
+	}
+	delete(m.codes, email)
+	return nil
+}
+
+// newCallbackTest sets up an auth service logging users in with the stub issuer, under the name stub
+func newCallbackTest(t *testing.T) (AuthService, JWTService, *memoryDatabase, *stubIssuer) {
+	issuer := newStubIssuer(t)
+	db := newMemoryDatabase()
+
+	store, err := repositories.NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+	jwt_config := config.JWTConfig{Algorithm: "RS256", Issuer: "gists", Audience: []string{"gists"}}
+	keyring, err := LoadKeyring(jwt_config, store)
+	require.NoError(t, err)
+	hasher := NewTokenHasher("pepper")
+	jwt_service := NewJWTService(keyring, db, hasher, jwt_config)
+
+	auth_service, err := NewAuthService(config.AuthProviders{{
+		Name:         "stub",
+		Type:         "oidc",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "http://localhost/auth/stub/callback",
+		Issuer:       issuer.URL(),
+	}}, nil, jwt_service, NewUserService(db), db, nil, hasher, nil, config.VerificationCodeConfig{}, config.MagicLinkConfig{}, config.AccountLinkingConfig{}, config.ReturnToConfig{})
+	require.NoError(t, err)
+	return auth_service, jwt_service, db, issuer
+}
+
+// login goes through the callback of a redirect login of the user subject with the stub issuer
+func login(t *testing.T, auth_service AuthService, issuer *stubIssuer, subject string) *types.AuthTokens {
+	issuer.Subject = subject
+	issuer.IDToken = issuer.sign(t, issuer.key, issuer.claims())
+
+	flow := &AuthFlow{
+		Provider:  "stub",
+		State:     "state",
+		Verifier:  oauth2.GenerateVerifier(),
+		Nonce:     "nonce",
+		ExpiresAt: time.Now().Add(AuthFlowLifetime).Unix(),
+	}
+	tokens, err := auth_service.Callback(flow, "code", ClientInfo{})
+	require.NoError(t, err)
+	return tokens
+}
+
+func TestCallbackFirstLogin(t *testing.T) {
+	auth_service, jwt_service, db, issuer := newCallbackTest(t)
+
+	tokens := login(t, auth_service, issuer, "subject")
+
+	require.Len(t, db.users, 1)
+	require.Len(t, db.identities, 1)
+	identity := db.identities[0]
+	assert.Equal(t, "stub:subject", identity.ID)
+	assert.Equal(t, "stub", identity.Provider)
+
+	claims, err := jwt_service.VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, identity.UserID, claims.UserID)
+	assert.Equal(t, "stub:subject", claims.Subject)
+	assert.NotEmpty(t, claims.SessionID)
+}
+
+func TestCallbackReturningLogin(t *testing.T) {
+	auth_service, jwt_service, db, issuer := newCallbackTest(t)
+
+	first, err := jwt_service.VerifyAccessToken(login(t, auth_service, issuer, "subject").AccessToken)
+	require.NoError(t, err)
+	second, err := jwt_service.VerifyAccessToken(login(t, auth_service, issuer, "subject").AccessToken)
+	require.NoError(t, err)
+
+	// the user is found through the identity rather than registered again
+	assert.Len(t, db.users, 1)
+	assert.Len(t, db.identities, 1)
+	assert.Equal(t, first.UserID, second.UserID)
+	assert.NotEqual(t, first.SessionID, second.SessionID)
+}
+
+func TestCallbackUserWithSeveralIdentities(t *testing.T) {
+	auth_service, jwt_service, db, issuer := newCallbackTest(t)
+
+	user, err := db.CreateUser(&types.User{Username: "user", Email: "user@example.com"})
+	require.NoError(t, err)
+	for _, subject := range []string{"first-identity", "second-identity"} {
+		_, err := db.CreateFederatedIdentity(&types.FederatedIdentity{ID: "stub:" + subject, UserID: user.ID, Provider: "stub"})
+		require.NoError(t, err)
+	}
+
+	tokens := login(t, auth_service, issuer, "second-identity")
+
+	// the token carries the identity used to log in, not the first identity of the user
+	claims, err := jwt_service.VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, "stub:second-identity", claims.Subject)
+	assert.Len(t, db.users, 1)
+
+	// and renewed tokens keep it
+	renewed, err := auth_service.Renew(tokens.RefreshToken, ClientInfo{})
+	require.NoError(t, err)
+	renewed_claims, err := jwt_service.VerifyAccessToken(renewed.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, renewed_claims.UserID)
+	assert.Equal(t, "stub:second-identity", renewed_claims.Subject)
+	assert.Equal(t, claims.SessionID, renewed_claims.SessionID)
+}
+
+func TestCallbackIdentitiesOfOtherProviders(t *testing.T) {
+	auth_service, jwt_service, db, issuer := newCallbackTest(t)
+
+	user, err := db.CreateUser(&types.User{Username: "user", Email: "user@example.com"})
+	require.NoError(t, err)
+	for _, identity := range []*types.FederatedIdentity{
+		{ID: "local:user@example.com", UserID: user.ID, Provider: "local"},
+		{ID: "github:12345", UserID: user.ID, Provider: "github"},
+	} {
+		_, err := db.CreateFederatedIdentity(identity)
+		require.NoError(t, err)
+	}
+
+	// a provider asserting the id of another provider's user, or the email of a local user, logs in a user of its own
+	for _, subject := range []string{"user@example.com", "12345", "local:user@example.com"} {
+		claims, err := jwt_service.VerifyAccessToken(login(t, auth_service, issuer, subject).AccessToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, user.ID, claims.UserID, subject)
+		assert.Equal(t, "stub:"+subject, claims.Subject)
+	}
+	assert.Len(t, db.users, 4)
+}
+
+func TestRenewConsumesRefreshToken(t *testing.T) {
+	auth_service, _, _, issuer := newCallbackTest(t)
+
+	tokens := login(t, auth_service, issuer, "subject")
+	_, err := auth_service.Renew(tokens.RefreshToken, ClientInfo{})
+	require.NoError(t, err)
+
+	_, err = auth_service.Renew(tokens.RefreshToken, ClientInfo{})
+	assert.Equal(t, ErrRefreshTokenReused, err)
+}
+
+func TestVerifyAccessTokenIssuerAndAudience(t *testing.T) {
+	auth_service, jwt_service, _, issuer := newCallbackTest(t)
+	tokens := login(t, auth_service, issuer, "subject")
+	keyring := jwt_service.(*jwtService).keyring
+
+	claims, err := verifier.New(keyring, "gists", "gists").VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, jwt.ClaimStrings{"gists"}, claims.Audience)
+
+	// the access tokens meant for another service are rejected by the services checking their audience
+	_, err = verifier.New(keyring, "gists", "other").VerifyAccessToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	_, err = verifier.New(keyring, "other", "gists").VerifyAccessToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+
+	// the ID tokens issued to OAuth clients are signed with the same keys, but aren't access tokens
+	id_token, err := jwt_service.Sign(&IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "http://localhost:4000",
+			Subject:   claims.UserID,
+			Audience:  jwt.ClaimStrings{"gists"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	require.NoError(t, err)
+	_, err = jwt_service.VerifyAccessToken(id_token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+	_, err = verifier.New(keyring, "gists", "gists").VerifyAccessToken(id_token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+}
+
+func TestVerifyAuthTokenCountsAttemptsFirst(t *testing.T) {
+	db := newMemoryDatabase()
+	hasher := NewTokenHasher("pepper")
+	codes, err := utils.NewCodeGenerator(6, utils.DigitsAlphabet)
+	require.NoError(t, err)
+	auth_service, err := NewAuthService(config.AuthProviders{}, nil, nil, NewUserService(db), db, nil, hasher, codes, config.VerificationCodeConfig{MaxAttempts: 3}, config.MagicLinkConfig{}, config.AccountLinkingConfig{}, config.ReturnToConfig{})
+	require.NoError(t, err)
+	send := func(attempts int) {
+		db.codes["user@example.com"] = &types.VerificationToken{
+			Email:     "user@example.com",
+			Token:     hasher.Hash("123456"),
+			ExpiresAt: time.Now().Add(time.Minute).Format(time.RFC3339),
+			Attempts:  attempts,
+		}
+	}
+
+	// the last attempt left burns the code when it is wrong
+	send(2)
+	_, err = auth_service.VerifyAuthToken("000000", "user@example.com", ClientInfo{})
+	assert.Equal(t, ErrInvalidCode, err)
+	assert.NotContains(t, db.codes, "user@example.com")
+
+	// concurrent attempts may use up the code before it is burnt, the right code is then rejected without being compared
+	send(3)
+	_, err = auth_service.VerifyAuthToken("123456", "user@example.com", ClientInfo{})
+	assert.Equal(t, ErrInvalidCode, err)
+	assert.Equal(t, 3, db.codes["user@example.com"].Attempts)
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// TokenHasher derives the value stored in the database from a secret token,
+// so that reading the database doesn't give away usable credentials
+type TokenHasher interface {
+	Hash(token string) string
+}
+
+// hmacTokenHasher keys the hash with a server side pepper, which never goes to the database
+type hmacTokenHasher struct {
+	pepper []byte
+}
+
+func NewTokenHasher(pepper string) TokenHasher {
+	return hmacTokenHasher{
+		pepper: []byte(pepper),
+	}
+}
+
+func (h hmacTokenHasher) Hash(token string) string {
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
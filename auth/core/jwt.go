@@ -1,111 +1,323 @@
 package core
 
 import (
+	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/gistsapp/api/auth/config"
 	"github.com/gistsapp/api/auth/repositories"
 	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type JWTService interface {
 	CreateAccessToken(claims *types.JWTClaims) (string, error)
-	CreateRefreshToken(userID string) (string, error)
+	CreateRefreshToken(userID string, identityID string, client ClientInfo) (*types.OpaqueToken, error)
+	CreateClientRefreshToken(userID string, identityID string, clientID string, scope string, client ClientInfo) (*types.OpaqueToken, error)
+	Sign(claims jwt.Claims) (string, error)
 	VerifyAccessToken(token string) (*types.JWTClaims, error)
 	VerifyRefreshToken(token string) (string, error)
+	RotateRefreshToken(token string, clientID string, client ClientInfo) (*types.OpaqueToken, error)
 	InvalidateRefreshToken(token string) error
+	RevokeAccessToken(claims *types.JWTClaims) error
+	RevokeAllTokens(userID string) error
+	JWKS() types.JWKS
+	SigningKeys() []*SigningKey
+	AddSigningKey(algorithm string, activatesAt time.Time) (*SigningKey, error)
+	RetireSigningKey(kid string, retiresAt time.Time) error
+	RotateSigningKey(algorithm string) (*SigningKey, error)
 }
 
+// ClientInfo describes the device a session is opened from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func NewClientInfo(c *fiber.Ctx) ClientInfo {
+	return ClientInfo{
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}
+}
+
+// a retired key must stay valid at least this long, for the tokens it signed to expire
+const AccessTokenLifetime = time.Hour * 24
+
 type jwtService struct {
-	secretKey string
-	db        repositories.Database
+	keyring *Keyring
+	db      repositories.Database
+	hasher  TokenHasher
+	config  config.JWTConfig
 }
 
-func NewJWTService(secretKey string, db repositories.Database) JWTService {
+func NewJWTService(keyring *Keyring, db repositories.Database, hasher TokenHasher, config config.JWTConfig) JWTService {
 	return &jwtService{
-		secretKey: secretKey,
-		db:        db,
+		keyring: keyring,
+		db:      db,
+		hasher:  hasher,
+		config:  config,
 	}
 }
 
+// CreateAccessToken fills in the registered claims of the token, but its subject, and signs it.
+// The token is meant for the services of the config, and expires after AccessTokenLifetime, or earlier if the claims already expire before.
 func (j jwtService) CreateAccessToken(claims *types.JWTClaims) (string, error) {
+	expires_at := time.Now().Add(AccessTokenLifetime)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expires_at) {
+		expires_at = claims.ExpiresAt.Time
+	}
 	claims.RegisteredClaims = jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
+		ExpiresAt: jwt.NewNumericDate(expires_at),
+		ID:        uuid.New().String(),
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		NotBefore: jwt.NewNumericDate(time.Now()),
 		Subject:   claims.Subject,
-		Issuer:    "gists",
+		Audience:  j.config.Audience,
+		Issuer:    j.config.Issuer,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secretKey))
+	return j.Sign(claims)
 }
 
-func (j jwtService) CreateRefreshToken(userID string) (string, error) {
-	tokenValue := uuid.New().String()
+// Sign signs the claims as they are with the current signing key, for tokens other than access tokens
+func (j jwtService) Sign(claims jwt.Claims) (string, error) {
+	key, err := j.keyring.SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// CreateRefreshToken issues the first refresh token of a new family, a family being a session opened by a single login
+// with the identity identityID
+func (j jwtService) CreateRefreshToken(userID string, identityID string, client ClientInfo) (*types.OpaqueToken, error) {
+	now := time.Now().Format(time.RFC3339)
+	return j.createRefreshToken(&types.OpaqueToken{
+		UserID:              userID,
+		FamilyID:            uuid.New().String(),
+		CreatedAt:           now,
+		LastUsedAt:          &now,
+		UserAgent:           client.UserAgent,
+		IP:                  client.IP,
+		FederatedIdentityID: &identityID,
+	})
+}
 
+// CreateClientRefreshToken issues the first refresh token of a session opened by an OAuth client, which alone can renew it
+func (j jwtService) CreateClientRefreshToken(userID string, identityID string, clientID string, scope string, client ClientInfo) (*types.OpaqueToken, error) {
+	now := time.Now().Format(time.RFC3339)
+	return j.createRefreshToken(&types.OpaqueToken{
+		UserID:              userID,
+		FamilyID:            uuid.New().String(),
+		CreatedAt:           now,
+		LastUsedAt:          &now,
+		UserAgent:           client.UserAgent,
+		IP:                  client.IP,
+		FederatedIdentityID: &identityID,
+		ClientID:            &clientID,
+		Scope:               &scope,
+	})
+}
+
+// createRefreshToken stores a new token value for the session described by opaqueToken.
+// Only the hash of the value is stored, the returned token holding the value itself.
+func (j jwtService) createRefreshToken(opaqueToken *types.OpaqueToken) (*types.OpaqueToken, error) {
+	tokenValue := uuid.New().String()
 	expiresAt := time.Now().Add(time.Hour * 24 * 7)
-	expiresAtStr := expiresAt.Format(time.RFC3339)
 
-	opaqueToken := &types.OpaqueToken{
-		ID:        uuid.New().String(),
-		UserID:    userID,
-		Token:     tokenValue,
-		ExpiresAt: expiresAtStr,
-	}
+	opaqueToken.ID = uuid.New().String()
+	opaqueToken.Token = j.hasher.Hash(tokenValue)
+	opaqueToken.ExpiresAt = expiresAt.Format(time.RFC3339)
+	opaqueToken.RotatedAt = nil
 
-	_, err := j.db.CreateOpaqueToken(opaqueToken)
+	created, err := j.db.CreateOpaqueToken(opaqueToken)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return tokenValue, nil
+	created.Token = tokenValue
+	return created, nil
 }
 
+// VerifyAccessToken checks the token with the keyring, and that it wasn't revoked.
+// The auth service accepts its access tokens whichever services they are meant for.
 func (j jwtService) VerifyAccessToken(tokenString string) (*types.JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &types.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return []byte(j.secretKey), nil
-	})
-
+	claims, err := verifier.Parse(tokenString, j.keyring, j.config.Issuer, "")
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*types.JWTClaims); ok && token.Valid {
-		return claims, nil
+	revoked, err := j.db.IsAccessTokenRevoked(claims.ID, claims.UserID, claims.SessionID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrAccessTokenRevoked
 	}
 
-	return nil, jwt.ErrTokenMalformed
+	return claims, nil
 }
 
 func (j jwtService) VerifyRefreshToken(tokenString string) (string, error) {
-	opaqueToken, err := j.db.GetOpaqueTokenByToken(tokenString)
+	opaqueToken, err := j.getRefreshToken(tokenString)
 	if err != nil {
 		return "", err
 	}
+	return opaqueToken.UserID, nil
+}
+
+// RotateRefreshToken consumes a refresh token and issues its successor in the same family.
+// The successor carries the start date, the identity and the OAuth client of the session, and the device it was last used from.
+// clientID is the OAuth client renewing the session, empty for our own frontend: a session can only be renewed by the client that opened it.
+func (j jwtService) RotateRefreshToken(tokenString string, clientID string, client ClientInfo) (*types.OpaqueToken, error) {
+	opaqueToken, err := j.getRefreshToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if opaqueToken.ClientID == nil && clientID != "" || opaqueToken.ClientID != nil && *opaqueToken.ClientID != clientID {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// rotated tokens are kept until they expire, so that presenting them again can be detected
+	err = j.db.RotateOpaqueToken(opaqueToken.ID)
+	if err == sql.ErrNoRows { // another request rotated it in the meantime
+		return nil, j.revokeReusedFamily(opaqueToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	return j.createRefreshToken(&types.OpaqueToken{
+		UserID:              opaqueToken.UserID,
+		FamilyID:            opaqueToken.FamilyID,
+		CreatedAt:           opaqueToken.CreatedAt,
+		LastUsedAt:          &now,
+		UserAgent:           client.UserAgent,
+		IP:                  client.IP,
+		FederatedIdentityID: opaqueToken.FederatedIdentityID,
+		ClientID:            opaqueToken.ClientID,
+		Scope:               opaqueToken.Scope,
+	})
+}
+
+// InvalidateRefreshToken revokes the whole family of the refresh token, i.e. the session it belongs to
+func (j jwtService) InvalidateRefreshToken(tokenString string) error {
+	opaqueToken, err := j.db.GetOpaqueTokenByToken(j.hasher.Hash(tokenString))
+	if err == sql.ErrNoRows {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return j.db.DeleteOpaqueTokenFamily(opaqueToken.FamilyID)
+}
+
+// RevokeAccessToken adds the token to the denylist until it expires
+func (j jwtService) RevokeAccessToken(claims *types.JWTClaims) error {
+	if claims.ExpiresAt == nil {
+		return jwt.ErrTokenMalformed
+	}
+	return j.db.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+// RevokeAllTokens ends every session of the user: its refresh tokens are deleted,
+// and the access tokens issued until now are rejected
+func (j jwtService) RevokeAllTokens(userID string) error {
+	if err := j.db.DeleteOpaqueTokensByUserID(userID); err != nil {
+		return err
+	}
+	return j.db.RevokeUserAccessTokens(userID, time.Now())
+}
+
+// getRefreshToken returns the refresh token if it can still be used.
+// A token that was already rotated means it leaked, so its whole family gets revoked.
+func (j jwtService) getRefreshToken(tokenString string) (*types.OpaqueToken, error) {
+	opaqueToken, err := j.db.GetOpaqueTokenByToken(j.hasher.Hash(tokenString))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opaqueToken.RotatedAt != nil {
+		return nil, j.revokeReusedFamily(opaqueToken)
+	}
 
 	expiresAt, err := time.Parse(time.RFC3339, opaqueToken.ExpiresAt)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if time.Now().After(expiresAt) {
 		j.db.DeleteOpaqueToken(opaqueToken.ID)
-		return "", errors.New("refresh token expired")
+		return nil, ErrRefreshTokenExpired
 	}
 
-	return opaqueToken.UserID, nil
+	return opaqueToken, nil
 }
 
-func (j jwtService) InvalidateRefreshToken(tokenString string) error {
-	opaqueToken, err := j.db.GetOpaqueTokenByToken(tokenString)
-	if err != nil {
+func (j jwtService) revokeReusedFamily(opaqueToken *types.OpaqueToken) error {
+	log.Warnw("Security event: refresh token reuse detected, revoking its family",
+		"user_id", opaqueToken.UserID,
+		"family_id", opaqueToken.FamilyID,
+		"token_id", opaqueToken.ID,
+	)
+	if err := j.db.DeleteOpaqueTokenFamily(opaqueToken.FamilyID); err != nil {
 		return err
 	}
+	return ErrRefreshTokenReused
+}
+
+func (j jwtService) JWKS() types.JWKS {
+	jwks := types.JWKS{
+		Keys: []types.JWK{},
+	}
+	for _, key := range j.keyring.Keys() {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
+
+func (j jwtService) SigningKeys() []*SigningKey {
+	return j.keyring.Keys()
+}
+
+func (j jwtService) AddSigningKey(algorithm string, activatesAt time.Time) (*SigningKey, error) {
+	return j.keyring.Add(algorithm, activatesAt)
+}
 
-	return j.db.DeleteOpaqueToken(opaqueToken.ID)
+func (j jwtService) RetireSigningKey(kid string, retiresAt time.Time) error {
+	return j.keyring.Retire(kid, retiresAt)
 }
+
+// RotateSigningKey publishes a new key, which starts signing tokens once the verifiers caching the JWKS know it,
+// and retires the current one once every token it signed has expired
+func (j jwtService) RotateSigningKey(algorithm string) (*SigningKey, error) {
+	current, err := j.keyring.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+	activates_at := time.Now().Add(JWKSCacheTTL)
+	key, err := j.keyring.Add(algorithm, activates_at)
+	if err != nil {
+		return nil, err
+	}
+	if err := j.keyring.Retire(current.ID, activates_at.Add(AccessTokenLifetime)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+var ErrInvalidRefreshToken error = errors.New("Invalid refresh token")
+var ErrRefreshTokenExpired error = errors.New("Refresh token expired")
+var ErrRefreshTokenReused error = errors.New("Refresh token was already used")
+var ErrAccessTokenRevoked error = errors.New("Access token was revoked")
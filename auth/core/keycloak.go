@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gistsapp/api/auth/config"
+	"github.com/markbates/goth"
+)
+
+const KeycloakProvider = "keycloak"
+
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// Keycloak is the Keycloak realm configured as an upstream identity provider.
+// Besides logging users in, it grants them roles and ends their sessions when they log out of Keycloak.
+type Keycloak struct {
+	provider       RedirectProvider
+	accessVerifier *oidc.IDTokenVerifier // Keycloak access tokens are JWTs signed with the keys of the realm
+	logoutVerifier *oidc.IDTokenVerifier
+	config         config.KeycloakConfig
+}
+
+func NewKeycloak(ctx context.Context, conf config.KeycloakConfig) (*Keycloak, error) {
+	issuer_url := strings.TrimSuffix(conf.URL, "/") + "/realms/" + conf.Realm
+	provider, err := NewOIDCProvider(ctx, config.AuthProvider{
+		Name:         KeycloakProvider,
+		Type:         "oidc",
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURI:  conf.CallbackURI,
+		Issuer:       issuer_url,
+		Scopes:       conf.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := oidc.NewProvider(ctx, issuer_url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keycloak{
+		provider:       provider,
+		accessVerifier: issuer.Verifier(&oidc.Config{SkipClientIDCheck: true}),
+		// logout tokens don't have to expire, their age is checked instead
+		logoutVerifier: issuer.Verifier(&oidc.Config{ClientID: conf.ClientID, SkipExpiryCheck: true}),
+		config:         conf,
+	}, nil
+}
+
+func (k *Keycloak) Provider() RedirectProvider {
+	return k.provider
+}
+
+// Roles maps the groups and roles Keycloak gave the user to our roles.
+// They are read from the ID token and the userinfo, and from the access token where Keycloak puts the roles by default.
+func (k *Keycloak) Roles(user goth.User) []string {
+	granted := keycloakGrants(user.RawData, k.config.ClientID)
+	if access_token, err := k.accessVerifier.Verify(context.Background(), user.AccessToken); err == nil {
+		claims := map[string]interface{}{}
+		if err := access_token.Claims(&claims); err == nil {
+			granted = append(granted, keycloakGrants(claims, k.config.ClientID)...)
+		}
+	}
+
+	roles := []string{}
+	for _, mapping := range k.config.Roles {
+		if slices.Contains(granted, mapping.From) && !slices.Contains(roles, mapping.To) {
+			roles = append(roles, mapping.To)
+		}
+	}
+	return roles
+}
+
+// VerifyLogoutToken checks a back-channel logout token sent by Keycloak, and returns the subject whose sessions ended
+func (k *Keycloak) VerifyLogoutToken(logout_token string) (string, error) {
+	token, err := k.logoutVerifier.Verify(context.Background(), logout_token)
+	if err != nil {
+		return "", err
+	}
+	if time.Since(token.IssuedAt) > 5*time.Minute {
+		return "", ErrInvalidLogoutToken
+	}
+
+	var claims struct {
+		Events map[string]interface{} `json:"events"`
+		Nonce  *string                `json:"nonce"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return "", err
+	}
+	// a nonce would mean this is an ID token, which must not be accepted as a logout token
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok || claims.Nonce != nil {
+		return "", ErrInvalidLogoutToken
+	}
+	if token.Subject == "" {
+		return "", ErrInvalidLogoutToken
+	}
+	return token.Subject, nil
+}
+
+// keycloakGrants lists the groups, realm roles and client roles found in the claims
+func keycloakGrants(claims map[string]interface{}, client_id string) []string {
+	grants := []string{}
+	for _, group := range claimStrings(claims["groups"]) {
+		grants = append(grants, "group:"+group)
+	}
+	if realm_access, ok := claims["realm_access"].(map[string]interface{}); ok {
+		for _, role := range claimStrings(realm_access["roles"]) {
+			grants = append(grants, "realm:"+role)
+		}
+	}
+	if resource_access, ok := claims["resource_access"].(map[string]interface{}); ok {
+		if client_access, ok := resource_access[client_id].(map[string]interface{}); ok {
+			for _, role := range claimStrings(client_access["roles"]) {
+				grants = append(grants, "client:"+role)
+			}
+		}
+	}
+	return grants
+}
+
+func claimStrings(claim interface{}) []string {
+	values, ok := claim.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+var ErrInvalidLogoutToken error = errors.New("Invalid logout token")
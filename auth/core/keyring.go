@@ -0,0 +1,261 @@
+package core
+
+import (
+	"crypto"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// the JWKS may be cached this long by the verifiers, so a new key must be published at least this long before it signs tokens
+const JWKSCacheTTL = 5 * time.Minute
+
+// the keys are read again from the store when a token is signed, or verified with an unknown key, at most this often
+const keyringReloadInterval = time.Minute
+
+// Keyring holds every signing key that is either signing tokens, scheduled to, or still accepted for verification.
+// Keys are published in the JWKS as soon as they are added, so that verifiers already know them when they start signing.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       []*SigningKey // sorted by activation date, newest first
+	store      repositories.KeyStore
+	reloadedAt time.Time
+}
+
+// LoadKeyring reads the keyring from the store.
+// When it is empty, it is seeded with the configured private key, or with a freshly generated one.
+func LoadKeyring(conf config.JWTConfig, store repositories.KeyStore) (*Keyring, error) {
+	keyring := &Keyring{
+		store: store,
+	}
+	if err := keyring.prune(); err != nil {
+		return nil, err
+	}
+	if err := keyring.reload(); err != nil {
+		return nil, err
+	}
+	if len(keyring.keys) > 0 {
+		return keyring, nil
+	}
+
+	var key *SigningKey
+	var err error
+	if conf.PrivateKey != "" {
+		key, err = ParseSigningKey([]byte(conf.PrivateKey))
+	} else {
+		log.Info("Signing keyring is empty, generating a ", conf.Algorithm, " key")
+		key, err = GenerateSigningKey(conf.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.save(key); err != nil {
+		return nil, err
+	}
+	return keyring, keyring.reload()
+}
+
+// SigningKey returns the key tokens must be signed with: the most recently activated one.
+// The keyring is read again first when a reload is due, so that the keys another instance added or retired are taken into account.
+func (k *Keyring) SigningKey() (*SigningKey, error) {
+	if k.reloadDue() {
+		if err := k.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range k.keys {
+		if !key.ActivatesAt.After(now) && !key.IsRetired(now) {
+			return key, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// VerificationKey returns the key identified by kid, as long as it isn't retired.
+// Unknown keys trigger a reload, in case another instance rotated the keyring, at most once every keyringReloadInterval.
+func (k *Keyring) VerificationKey(kid string) (*SigningKey, error) {
+	key := k.find(kid)
+	if key == nil && k.reloadDue() {
+		if err := k.reload(); err != nil {
+			return nil, err
+		}
+		key = k.find(kid)
+	}
+	if key == nil || key.IsRetired(time.Now()) {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+// PublicKey returns the public part of the verification key identified by kid, for verifier.Parse
+func (k *Keyring) PublicKey(kid string) (crypto.PublicKey, string, error) {
+	key, err := k.VerificationKey(kid)
+	if err != nil {
+		return nil, "", err
+	}
+	return key.PublicKey(), key.Algorithm, nil
+}
+
+// Keys returns the keys that are not retired yet
+func (k *Keyring) Keys() []*SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	keys := []*SigningKey{}
+	for _, key := range k.keys {
+		if !key.IsRetired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Add generates a new key that will start signing tokens at activatesAt
+func (k *Keyring) Add(algorithm string, activatesAt time.Time) (*SigningKey, error) {
+	key, err := GenerateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.ActivatesAt = activatesAt
+	if err := k.save(key); err != nil {
+		return nil, err
+	}
+	if err := k.prune(); err != nil {
+		return nil, err
+	}
+	return key, k.reload()
+}
+
+// Retire schedules the end of validity of a key.
+// The key still verifies tokens until retiresAt, so it should be far enough for the tokens it signed to expire.
+func (k *Keyring) Retire(kid string, retiresAt time.Time) error {
+	key := k.find(kid)
+	if key == nil {
+		return ErrUnknownSigningKey
+	}
+
+	// make sure some key is still able to sign tokens once this one is gone
+	successor := false
+	for _, other := range k.Keys() {
+		if other.ID != kid && other.ActivatesAt.Before(retiresAt) && (other.RetiresAt == nil || other.RetiresAt.After(retiresAt)) {
+			successor = true
+		}
+	}
+	if !successor {
+		return ErrLastSigningKey
+	}
+
+	retired := *key
+	retired.RetiresAt = &retiresAt
+	if err := k.save(&retired); err != nil {
+		return err
+	}
+	if err := k.prune(); err != nil {
+		return err
+	}
+	return k.reload()
+}
+
+func (k *Keyring) find(kid string) *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func (k *Keyring) save(key *SigningKey) error {
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	return k.store.SaveKey(&repositories.StoredKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		CreatedAt:   key.CreatedAt,
+		ActivatesAt: key.ActivatesAt,
+		RetiresAt:   key.RetiresAt,
+		PrivateKey:  data,
+	})
+}
+
+// reloadDue tells whether the keyring was last read from the store long enough ago, and if so, counts the coming reload
+func (k *Keyring) reloadDue() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.reloadedAt) < keyringReloadInterval {
+		return false
+	}
+	k.reloadedAt = time.Now()
+	return true
+}
+
+// reload reads the keys from the store, leaving out the ones that are retired
+func (k *Keyring) reload() error {
+	stored, err := k.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keys := []*SigningKey{}
+	for _, stored_key := range stored {
+		if stored_key.RetiresAt != nil && !stored_key.RetiresAt.After(now) {
+			continue
+		}
+		key, err := ParseSigningKey(stored_key.PrivateKey)
+		if err != nil {
+			return err
+		}
+		key.CreatedAt = stored_key.CreatedAt
+		key.ActivatesAt = stored_key.ActivatesAt
+		key.RetiresAt = stored_key.RetiresAt
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ActivatesAt.After(keys[j].ActivatesAt)
+	})
+
+	k.mu.Lock()
+	k.keys = keys
+	k.reloadedAt = now
+	k.mu.Unlock()
+	return nil
+}
+
+// prune deletes the retired keys from the store, when the keyring is changed rather than on the path of every verification
+func (k *Keyring) prune() error {
+	stored, err := k.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, stored_key := range stored {
+		if stored_key.RetiresAt != nil && !stored_key.RetiresAt.After(now) {
+			log.Info("Removing retired signing key ", stored_key.ID)
+			if err := k.store.DeleteKey(stored_key.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var ErrLastSigningKey error = errors.New("Can't retire the last key able to sign tokens")
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeyFollowsRotationByAnotherInstance(t *testing.T) {
+	store, err := repositories.NewFileKeyStore(t.TempDir())
+	require.NoError(t, err)
+	rotating, err := LoadKeyring(config.JWTConfig{Algorithm: "RS256"}, store)
+	require.NoError(t, err)
+	other, err := LoadKeyring(config.JWTConfig{Algorithm: "RS256"}, store)
+	require.NoError(t, err)
+
+	old, err := other.SigningKey()
+	require.NoError(t, err)
+
+	// the instance rotating the keyring promotes a new key and retires the old one right away
+	key, err := rotating.Add("RS256", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	require.NoError(t, rotating.Retire(old.ID, time.Now()))
+
+	// until a reload is due, the other instance signs with the keys it read
+	signing, err := other.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, signing.ID)
+
+	other.reloadedAt = time.Now().Add(-keyringReloadInterval)
+	signing, err = other.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, signing.ID)
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/gistsapp/api/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey is an asymmetric key used to sign access tokens.
+// Its ID is published as the kid header of every token it signs.
+// A key signs tokens once ActivatesAt is reached and until a newer key activates,
+// and is accepted for verification until RetiresAt.
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	RetiresAt   *time.Time
+}
+
+// GenerateSigningKey creates a new key for the given algorithm, RS256 being the default
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var signer crypto.Signer
+	switch algorithm {
+	case AlgorithmRS256, "":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		signer = key
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return newSigningKey(signer)
+}
+
+// ParseSigningKey reads a PKCS#8 PEM encoded RSA or Ed25519 private key
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidSigningKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidSigningKey
+	}
+	return newSigningKey(signer)
+}
+
+func newSigningKey(signer crypto.Signer) (*SigningKey, error) {
+	now := time.Now()
+	key := &SigningKey{
+		PrivateKey:  signer,
+		CreatedAt:   now,
+		ActivatesAt: now,
+	}
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	id, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+	return key, nil
+}
+
+func (k *SigningKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (k *SigningKey) Method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// IsRetired tells if the key can no longer be used to verify tokens
+func (k *SigningKey) IsRetired(at time.Time) bool {
+	return k.RetiresAt != nil && !at.Before(*k.RetiresAt)
+}
+
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// JWK returns the public part of the key, as published on the JWKS endpoint
+func (k *SigningKey) JWK() types.JWK {
+	jwk := types.JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Algorithm,
+	}
+	switch public := k.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the key, used as its kid
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk := k.JWK()
+	var members map[string]string
+	if jwk.KeyType == "RSA" {
+		members = map[string]string{"e": jwk.E, "kty": jwk.KeyType, "n": jwk.N}
+	} else {
+		members = map[string]string{"crv": jwk.Curve, "kty": jwk.KeyType, "x": jwk.X}
+	}
+	data, err := json.Marshal(members) // map keys are sorted, as required by the RFC
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+var ErrNoSigningKey error = errors.New("No signing key configured")
+var ErrInvalidSigningKey error = errors.New("Invalid signing key")
+var ErrUnsupportedAlgorithm error = errors.New("Unsupported signing algorithm")
+var ErrUnknownSigningKey error = errors.New("Unknown signing key")
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gistsapp/api/types"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/markbates/goth"
+)
+
+// how long a user has to complete the login with the provider they are linking
+const LinkIntentLifetime = time.Minute * 10
+
+// LinkIntent returns the signed value remembering, across the redirection to the provider,
+// that the user is linking it to their account rather than logging in
+func (a *authService) LinkIntent(user_id string, provider string) (string, error) {
+	if _, err := goth.GetProvider(provider); err != nil {
+		return "", UnkownProvider
+	}
+	payload := strings.Join([]string{"link", user_id, provider, strconv.FormatInt(time.Now().Add(LinkIntentLifetime).Unix(), 10)}, "|")
+	return payload + "|" + a.hasher.Hash(payload), nil
+}
+
+// Link completes the login of the flow, verified beforehand with VerifyAuthFlow, and links the identity to the user who started it
+func (a *authService) Link(flow *AuthFlow, code string, link_intent string) (*types.FederatedIdentity, error) {
+	user_id, err := a.verifyLinkIntent(link_intent, flow.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	auth_user, err := a.completeRedirect(flow, code)
+	if err != nil {
+		log.Warnw("Couldn't complete the login with the provider", "provider", flow.Provider, "error", err)
+		return nil, ErrCantCompleteAuth
+	}
+
+	federated_identity, err := a.database.GetFederatedIdentityByID(auth_user.UserID)
+	if err == nil {
+		if federated_identity.UserID != user_id {
+			return nil, ErrIdentityAlreadyLinked
+		}
+		return federated_identity, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	return a.linkIdentity(user_id, auth_user)
+}
+
+// Identities lists the identities the user can log in with
+func (a *authService) Identities(user_id string) ([]*types.FederatedIdentity, error) {
+	return a.database.GetFederatedIdentitiesByUserID(user_id)
+}
+
+// Unlink removes an identity of the user, who must keep at least one to log in with
+func (a *authService) Unlink(user_id string, federated_identity_id string) error {
+	err := a.database.DeleteUserFederatedIdentity(user_id, federated_identity_id)
+	if err == nil {
+		// the sessions opened with the identity end along with it
+		return a.database.DeleteOpaqueTokensByFederatedIdentity(user_id, federated_identity_id)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	// tell apart an identity that isn't the user's from their last one
+	federated_identity, err := a.database.GetFederatedIdentityByID(federated_identity_id)
+	if err == sql.ErrNoRows || (err == nil && federated_identity.UserID != user_id) {
+		return types.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLastIdentity
+}
+
+func (a *authService) verifyLinkIntent(link_intent string, provider string) (string, error) {
+	separator := strings.LastIndex(link_intent, "|")
+	if separator < 0 {
+		return "", ErrInvalidLinkIntent
+	}
+	payload, signature := link_intent[:separator], link_intent[separator+1:]
+	if !hmac.Equal([]byte(a.hasher.Hash(payload)), []byte(signature)) {
+		return "", ErrInvalidLinkIntent
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 4 || parts[0] != "link" || parts[2] != provider {
+		return "", ErrInvalidLinkIntent
+	}
+	expires_at, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || time.Now().Unix() > expires_at {
+		return "", ErrInvalidLinkIntent
+	}
+	return parts[1], nil
+}
+
+func (a *authService) linkIdentity(user_id string, auth_user goth.User) (*types.FederatedIdentity, error) {
+	data, err := json.Marshal(auth_user)
+	if err != nil {
+		return nil, err
+	}
+	return a.database.CreateFederatedIdentity(&types.FederatedIdentity{
+		ID:       auth_user.UserID,
+		Data:     string(data),
+		Provider: auth_user.Provider,
+		UserID:   user_id,
+	})
+}
+
+// autoLink finds the user a new identity belongs to, from the email the provider verified.
+// It only links when auto-linking is enabled and exactly one user has that email.
+func (a *authService) autoLink(auth_user goth.User) (*types.User, error) {
+	if !a.linkingConfig.AutoLink || auth_user.Email == "" || !hasVerifiedEmail(auth_user) {
+		return nil, nil
+	}
+	users, err := a.database.GetUsersByEmail(auth_user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		return nil, nil
+	}
+	if _, err := a.linkIdentity(users[0].ID, auth_user); err != nil {
+		return nil, err
+	}
+	log.Infow("Linked a new identity through its verified email", "provider", auth_user.Provider, "user_id", users[0].ID)
+	return users[0], nil
+}
+
+// hasVerifiedEmail tells if the provider vouches for the email of the user:
+// local emails are verified by the code sent to them, GitHub only exposes verified emails,
+// and OpenID Connect providers, Google included, say so in a claim
+func hasVerifiedEmail(auth_user goth.User) bool {
+	if auth_user.Provider == "local" || auth_user.Provider == "github" {
+		return true
+	}
+	for _, claim := range []string{"email_verified", "verified_email"} {
+		if verified, ok := auth_user.RawData[claim].(bool); ok && verified {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrInvalidLinkIntent error = errors.New("Invalid or expired link request")
+var ErrIdentityAlreadyLinked error = errors.New("This identity is already linked to another account")
+var ErrLastIdentity error = errors.New("Can't unlink the last identity of the account")
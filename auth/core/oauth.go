@@ -0,0 +1,567 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gistsapp/api/auth/utils"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// grant types of the token endpoint
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// scopes the OpenID Connect endpoints understand, clients can be given others
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// OAuthServer makes the auth service the OAuth 2.1 / OpenID Connect provider of our own apps.
+// Users log in to it like to our frontend, it then hands authorization codes to the registered clients.
+type OAuthServer interface {
+	RegisterClient(registration *ClientRegistration) (*types.OAuthClient, string, error)
+	Clients() ([]*types.OAuthClient, error)
+	DeleteClient(client_id string) error
+	ValidateAuthorizationRequest(request *AuthorizationRequest) (*types.OAuthClient, error)
+	Authorize(request *AuthorizationRequest, claims *types.JWTClaims) (string, error)
+	Token(request *TokenRequest, client ClientInfo) (*TokenResponse, error)
+	UserInfo(claims *types.JWTClaims) (*UserInfo, error)
+	Discovery() *DiscoveryDocument
+}
+
+type ClientRegistration struct {
+	Name         string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+	Public       bool // public clients, like single page or mobile apps, can't keep a secret and only rely on PKCE
+}
+
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+type TokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+}
+
+// IDTokenClaims tell a client who logged in, their subject being the id of the user
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type DiscoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	AuthorizationResponseIssSupported bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// OAuthError is an error of the OAuth protocol, sent back to the client along with its code
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Description
+}
+
+type oauthServer struct {
+	jwtService JWTService
+	database   repositories.Database
+	hasher     TokenHasher
+	config     config.OAuthServerConfig
+}
+
+func NewOAuthServer(jwtService JWTService, database repositories.Database, hasher TokenHasher, config config.OAuthServerConfig) OAuthServer {
+	return &oauthServer{
+		jwtService: jwtService,
+		database:   database,
+		hasher:     hasher,
+		config:     config,
+	}
+}
+
+// RegisterClient creates a client, returning its secret along with it: only its hash is stored, it can't be shown again
+func (o *oauthServer) RegisterClient(registration *ClientRegistration) (*types.OAuthClient, string, error) {
+	for _, grant_type := range registration.GrantTypes {
+		if grant_type != GrantAuthorizationCode && grant_type != GrantRefreshToken && grant_type != GrantClientCredentials {
+			return nil, "", ErrUnsupportedGrantType
+		}
+	}
+	if registration.Public && slices.Contains(registration.GrantTypes, GrantClientCredentials) {
+		return nil, "", ErrPublicClientCredentials
+	}
+	if slices.Contains(registration.GrantTypes, GrantAuthorizationCode) && len(registration.RedirectURIs) == 0 {
+		return nil, "", ErrInvalidRedirectURI
+	}
+	for _, redirect_uri := range registration.RedirectURIs {
+		parsed, err := url.Parse(redirect_uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(redirect_uri, " \t\n") {
+			return nil, "", ErrInvalidRedirectURI
+		}
+	}
+	for _, scope := range registration.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	client := &types.OAuthClient{
+		ID:           uuid.New().String(),
+		Name:         registration.Name,
+		RedirectURIs: strings.Join(registration.RedirectURIs, " "),
+		GrantTypes:   strings.Join(registration.GrantTypes, " "),
+		Scopes:       strings.Join(registration.Scopes, " "),
+	}
+	secret := ""
+	if !registration.Public {
+		var err error
+		secret, err = utils.RandomToken(32)
+		if err != nil {
+			return nil, "", err
+		}
+		secret_hash := o.hasher.Hash(secret)
+		client.Secret = &secret_hash
+	}
+
+	created, err := o.database.CreateOAuthClient(client)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, secret, nil
+}
+
+func (o *oauthServer) Clients() ([]*types.OAuthClient, error) {
+	return o.database.GetOAuthClients()
+}
+
+// DeleteClient removes the client, along with the sessions it opened
+func (o *oauthServer) DeleteClient(client_id string) error {
+	err := o.database.DeleteOAuthClient(client_id)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// ValidateAuthorizationRequest checks the request before the user is asked to log in.
+// An unknown client or redirect URI is reported to the user, as redirecting to it would be unsafe;
+// once the redirect URI is known, the other errors are OAuthErrors to send back to the client.
+func (o *oauthServer) ValidateAuthorizationRequest(request *AuthorizationRequest) (*types.OAuthClient, error) {
+	client, err := o.database.GetOAuthClientByID(request.ClientID)
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	redirect_uris := strings.Fields(client.RedirectURIs)
+	if request.RedirectURI == "" && len(redirect_uris) == 1 {
+		request.RedirectURI = redirect_uris[0]
+	}
+	// redirect URIs are compared as they are, never as prefixes
+	if !slices.Contains(redirect_uris, request.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if request.ResponseType != "code" {
+		return client, ErrUnsupportedResponseType
+	}
+	if !slices.Contains(strings.Fields(client.GrantTypes), GrantAuthorizationCode) {
+		return client, ErrUnauthorizedClient
+	}
+	if request.CodeChallenge == "" || request.CodeChallengeMethod != "S256" {
+		return client, ErrPKCERequired
+	}
+	scope, err := grantedScope(client, request.Scope)
+	if err != nil {
+		return client, err
+	}
+	request.Scope = scope
+	return client, nil
+}
+
+// Authorize hands the client a code for the user logged in with claims, and returns the URL to redirect the user to.
+// The request must have been validated with ValidateAuthorizationRequest.
+func (o *oauthServer) Authorize(request *AuthorizationRequest, claims *types.JWTClaims) (string, error) {
+	redirect_url, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		return "", ErrInvalidRedirectURI
+	}
+	code_value, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	var nonce *string
+	if request.Nonce != "" {
+		nonce = &request.Nonce
+	}
+
+	_, err = o.database.CreateAuthorizationCode(&types.AuthorizationCode{
+		Code:                o.hasher.Hash(code_value),
+		ClientID:            request.ClientID,
+		UserID:              claims.UserID,
+		FederatedIdentityID: claims.Subject,
+		RedirectURI:         request.RedirectURI,
+		Scope:               request.Scope,
+		CodeChallenge:       request.CodeChallenge,
+		Nonce:               nonce,
+		ExpiresAt:           time.Now().Add(o.config.CodeTTL).Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	query := redirect_url.Query()
+	query.Set("code", code_value)
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	query.Set("iss", o.config.Issuer) // lets the client check which server answered, see RFC 9207
+	redirect_url.RawQuery = query.Encode()
+	return redirect_url.String(), nil
+}
+
+// Token authenticates the client and issues tokens for its grant
+func (o *oauthServer) Token(request *TokenRequest, client_info ClientInfo) (*TokenResponse, error) {
+	if request.GrantType != GrantAuthorizationCode && request.GrantType != GrantRefreshToken && request.GrantType != GrantClientCredentials {
+		return nil, ErrUnsupportedGrantType
+	}
+	client, err := o.authenticateClient(request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(strings.Fields(client.GrantTypes), request.GrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	switch request.GrantType {
+	case GrantAuthorizationCode:
+		return o.exchangeCode(client, request, client_info)
+	case GrantRefreshToken:
+		return o.refresh(client, request, client_info)
+	default:
+		return o.clientCredentials(client, request)
+	}
+}
+
+// UserInfo describes the user of the access token, with the claims of the scopes granted to its client.
+// Only the tokens of a login session on our own apps, which aren't verifier.Scoped, get every claim: personal access tokens are limited to their scope too.
+func (o *oauthServer) UserInfo(claims *types.JWTClaims) (*UserInfo, error) {
+	user, err := o.database.GetUserByID(claims.UserID)
+	if err == sql.ErrNoRows {
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	scopes := strings.Fields(claims.Scope)
+	session := !verifier.Scoped(claims)
+	user_info := &UserInfo{
+		Subject: user.ID,
+	}
+	if session || slices.Contains(scopes, ScopeEmail) {
+		user_info.Email = user.Email
+	}
+	if session || slices.Contains(scopes, ScopeProfile) {
+		user_info.PreferredUsername = user.Username
+		user_info.Picture = user.Picture
+	}
+	return user_info, nil
+}
+
+func (o *oauthServer) Discovery() *DiscoveryDocument {
+	issuer := strings.TrimSuffix(o.config.Issuer, "/")
+	return &DiscoveryDocument{
+		Issuer:                            o.config.Issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{AlgorithmRS256, AlgorithmEdDSA},
+		ScopesSupported:                   []string{ScopeOpenID, ScopeEmail, ScopeProfile},
+		ClaimsSupported:                   []string{"sub", "email", "preferred_username", "picture"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		AuthorizationResponseIssSupported: true,
+	}
+}
+
+// authenticateClient checks the secret of a confidential client, public clients having to send none
+func (o *oauthServer) authenticateClient(client_id string, secret string) (*types.OAuthClient, error) {
+	if client_id == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := o.database.GetOAuthClientByID(client_id)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Secret == nil {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	if secret == "" || !hmac.Equal([]byte(o.hasher.Hash(secret)), []byte(*client.Secret)) {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// exchangeCode consumes the authorization code, which only the client that asked for it can do, proving it with its PKCE verifier
+func (o *oauthServer) exchangeCode(client *types.OAuthClient, request *TokenRequest, client_info ClientInfo) (*TokenResponse, error) {
+	if request.Code == "" || request.CodeVerifier == "" {
+		return nil, ErrInvalidGrant
+	}
+	code, err := o.database.ConsumeAuthorizationCode(o.hasher.Hash(request.Code))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if code.ClientID != client.ID || (request.RedirectURI != "" && request.RedirectURI != code.RedirectURI) {
+		return nil, ErrInvalidGrant
+	}
+	expires_at, err := time.Parse(time.RFC3339, code.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expires_at) {
+		return nil, ErrInvalidGrant
+	}
+	challenge := sha256.Sum256([]byte(request.CodeVerifier))
+	if !hmac.Equal([]byte(base64.RawURLEncoding.EncodeToString(challenge[:])), []byte(code.CodeChallenge)) {
+		return nil, ErrInvalidGrant
+	}
+
+	user, err := o.database.GetUserByID(code.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		TokenType: "Bearer",
+		ExpiresIn: int(AccessTokenLifetime.Seconds()),
+		Scope:     code.Scope,
+	}
+	session_id := ""
+	if slices.Contains(strings.Fields(client.GrantTypes), GrantRefreshToken) {
+		refresh_token, err := o.jwtService.CreateClientRefreshToken(user.ID, code.FederatedIdentityID, client.ID, code.Scope, client_info)
+		if err != nil {
+			return nil, err
+		}
+		session_id = refresh_token.FamilyID
+		response.RefreshToken = refresh_token.Token
+	}
+	response.AccessToken, err = o.createAccessToken(user.ID, code.FederatedIdentityID, session_id, client.ID, code.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(strings.Fields(code.Scope), ScopeOpenID) {
+		response.IDToken, err = o.createIDToken(user, client.ID, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// refresh renews a session the client opened, with the scope it was granted then
+func (o *oauthServer) refresh(client *types.OAuthClient, request *TokenRequest, client_info ClientInfo) (*TokenResponse, error) {
+	rotated_token, err := o.jwtService.RotateRefreshToken(request.RefreshToken, client.ID, client_info)
+	if err == ErrInvalidRefreshToken || err == ErrRefreshTokenExpired || err == ErrRefreshTokenReused {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rotated_token.FederatedIdentityID == nil || rotated_token.Scope == nil {
+		return nil, ErrInvalidGrant
+	}
+
+	access_token, err := o.createAccessToken(rotated_token.UserID, *rotated_token.FederatedIdentityID, rotated_token.FamilyID, client.ID, *rotated_token.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken:  access_token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTokenLifetime.Seconds()),
+		RefreshToken: rotated_token.Token,
+		Scope:        *rotated_token.Scope,
+	}, nil
+}
+
+// clientCredentials issues a short-lived access token to the client itself, a service acting for no user.
+// Nothing renews it, the service asks for a new one once it expires.
+func (o *oauthServer) clientCredentials(client *types.OAuthClient, request *TokenRequest) (*TokenResponse, error) {
+	if client.Secret == nil {
+		return nil, ErrUnauthorizedClient
+	}
+	scope, err := grantedScope(client, request.Scope)
+	if err != nil {
+		return nil, err
+	}
+	access_token, err := o.jwtService.CreateAccessToken(&types.JWTClaims{
+		ClientID: client.ID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   client.ID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(o.config.ServiceTokenTTL)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken: access_token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(o.config.ServiceTokenTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// createAccessToken issues an access token like the ones of our frontend, issued to the client and limited to scope
+func (o *oauthServer) createAccessToken(user_id string, identity_id string, session_id string, client_id string, scope string) (string, error) {
+	roles, err := o.database.GetUserRoles(user_id)
+	if err != nil {
+		return "", err
+	}
+	return o.jwtService.CreateAccessToken(&types.JWTClaims{
+		UserID:    user_id,
+		SessionID: session_id,
+		Roles:     roles,
+		ClientID:  client_id,
+		Scope:     scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: identity_id,
+		},
+	})
+}
+
+// createIDToken tells the client who logged in, with the claims of the scopes it was granted
+func (o *oauthServer) createIDToken(user *types.User, client_id string, code *types.AuthorizationCode) (string, error) {
+	now := time.Now()
+	claims := &IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    o.config.Issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{client_id},
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenLifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if code.Nonce != nil {
+		claims.Nonce = *code.Nonce
+	}
+	scopes := strings.Fields(code.Scope)
+	if slices.Contains(scopes, ScopeEmail) {
+		claims.Email = user.Email
+	}
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+		claims.Picture = user.Picture
+	}
+	return o.jwtService.Sign(claims)
+}
+
+// grantedScope checks the scope asked for against the scopes of the client, which are all granted when none is asked for
+func grantedScope(client *types.OAuthClient, requested string) (string, error) {
+	allowed := strings.Fields(client.Scopes)
+	if requested == "" {
+		return strings.Join(allowed, " "), nil
+	}
+	scopes := strings.Fields(requested)
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+var ErrUnknownClient error = errors.New("Unknown client")
+var ErrInvalidRedirectURI error = errors.New("Invalid redirect URI")
+var ErrPublicClientCredentials error = errors.New("Public clients can't use the client credentials grant")
+var ErrInvalidClient error = &OAuthError{Code: "invalid_client", Description: "Client authentication failed"}
+var ErrInvalidGrant error = &OAuthError{Code: "invalid_grant", Description: "Invalid, expired or already used grant"}
+var ErrUnauthorizedClient error = &OAuthError{Code: "unauthorized_client", Description: "The client isn't allowed to use this grant"}
+var ErrUnsupportedGrantType error = &OAuthError{Code: "unsupported_grant_type", Description: "Unsupported grant type"}
+var ErrUnsupportedResponseType error = &OAuthError{Code: "unsupported_response_type", Description: "Only the code response type is supported"}
+var ErrInvalidScope error = &OAuthError{Code: "invalid_scope", Description: "Invalid scope"}
+var ErrPKCERequired error = &OAuthError{Code: "invalid_request", Description: "PKCE with the S256 method is required"}
+var ErrLoginRequired error = &OAuthError{Code: "login_required", Description: "The user must log in first"}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserInfoScopes(t *testing.T) {
+	db := newMemoryDatabase()
+	user, err := db.CreateUser(&types.User{Email: "user@example.com", Username: "user", Picture: "https://example.com/user.png"})
+	require.NoError(t, err)
+	server := NewOAuthServer(nil, db, nil, config.OAuthServerConfig{})
+
+	tests := []struct {
+		name    string
+		claims  *types.JWTClaims
+		email   bool
+		profile bool
+	}{
+		{name: "session", claims: &types.JWTClaims{UserID: user.ID, SessionID: "session"}, email: true, profile: true},
+		{name: "client without scopes", claims: &types.JWTClaims{UserID: user.ID, SessionID: "session", ClientID: "client", Scope: "openid"}},
+		{name: "client with email", claims: &types.JWTClaims{UserID: user.ID, SessionID: "session", ClientID: "client", Scope: "openid email"}, email: true},
+		{name: "client with profile", claims: &types.JWTClaims{UserID: user.ID, SessionID: "session", ClientID: "client", Scope: "openid profile"}, profile: true},
+		{name: "personal access token", claims: &types.JWTClaims{UserID: user.ID, Scope: "gists:read"}},
+		{name: "personal access token with email", claims: &types.JWTClaims{UserID: user.ID, Scope: "email"}, email: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user_info, err := server.UserInfo(test.claims)
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, user_info.Subject)
+			assert.Equal(t, test.email, user_info.Email != "")
+			assert.Equal(t, test.profile, user_info.PreferredUsername != "")
+			assert.Equal(t, test.profile, user_info.Picture != "")
+		})
+	}
+
+	_, err = server.UserInfo(&types.JWTClaims{UserID: "unknown", SessionID: "session"})
+	assert.Equal(t, types.ErrNotFound, err)
+}
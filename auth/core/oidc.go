@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"slices"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gistsapp/api/auth/config"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider is an OpenID Connect provider declared in the config, whose endpoints come from the discovery document of its issuer.
+// Unlike the goth provider it wraps, it checks the signature of the ID tokens against the keys published by the issuer.
+type oidcProvider struct {
+	*openidConnect.Provider
+	verifier *oidc.IDTokenVerifier
+	config   *oauth2.Config
+}
+
+// oidcSession hands the wrapped provider to the goth session, which only knows how to authorize with it
+type oidcSession struct {
+	*openidConnect.Session
+	provider *openidConnect.Provider
+	nonce    string // the nonce of the login, which the ID token must carry
+}
+
+func NewOIDCProvider(ctx context.Context, conf config.AuthProvider) (RedirectProvider, error) {
+	if conf.Issuer == "" {
+		return nil, ErrMissingIssuer
+	}
+	issuer, err := oidc.NewProvider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := issuer.Claims(&metadata); err != nil {
+		return nil, err
+	}
+
+	endpoint := issuer.Endpoint()
+	provider, err := openidConnect.NewCustomisedURL(conf.ClientID, conf.ClientSecret, conf.RedirectURI, endpoint.AuthURL, endpoint.TokenURL, conf.Issuer, issuer.UserInfoEndpoint(), metadata.EndSessionEndpoint, conf.Scopes...)
+	if err != nil {
+		return nil, err
+	}
+	provider.SetName(conf.Name)
+
+	scopes := conf.Scopes
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &oidcProvider{
+		Provider: provider,
+		verifier: issuer.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		config:   newOAuth2Config(conf, endpoint, scopes),
+	}, nil
+}
+
+func (p *oidcProvider) BeginAuth(state string) (goth.Session, error) {
+	session, err := p.Provider.BeginAuth(state)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcSession{Session: session.(*openidConnect.Session), provider: p.Provider}, nil
+}
+
+func (p *oidcProvider) UnmarshalSession(data string) (goth.Session, error) {
+	session, err := p.Provider.UnmarshalSession(data)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcSession{Session: session.(*openidConnect.Session), provider: p.Provider}, nil
+}
+
+// FetchUser verifies the ID token obtained by the session, and that it was issued for this very login, before reading the user from its claims
+func (p *oidcProvider) FetchUser(session goth.Session) (goth.User, error) {
+	sess, ok := session.(*oidcSession)
+	if !ok {
+		return goth.User{}, ErrInvalidIDToken
+	}
+	id_token, err := p.verifier.Verify(context.Background(), sess.IDToken)
+	if err != nil {
+		return goth.User{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(id_token.Nonce), []byte(sess.nonce)) != 1 {
+		return goth.User{}, ErrInvalidIDToken
+	}
+	return p.Provider.FetchUser(sess.Session)
+}
+
+func (s *oidcSession) Authorize(_ goth.Provider, params goth.Params) (string, error) {
+	return s.Session.Authorize(s.provider, params)
+}
+
+var ErrMissingIssuer error = errors.New("OIDC providers need an issuer")
+var ErrInvalidIDToken error = errors.New("Invalid ID token")
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIssuer is a local OpenID Connect provider serving its discovery document, its keys,
+// and whatever ID token it is told to issue from its token endpoint, for the user Subject
+type stubIssuer struct {
+	server  *httptest.Server
+	key     *SigningKey
+	IDToken string
+	Subject string
+	Email   string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := GenerateSigningKey("RS256")
+	require.NoError(t, err)
+	issuer := &stubIssuer{key: key, Subject: "subject", Email: "user@example.com"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 issuer.URL(),
+			"authorization_endpoint": issuer.URL() + "/authorize",
+			"token_endpoint":         issuer.URL() + "/token",
+			"userinfo_endpoint":      issuer.URL() + "/userinfo",
+			"jwks_uri":               issuer.URL() + "/jwks",
+			"end_session_endpoint":   issuer.URL() + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(types.JWKS{Keys: []types.JWK{key.JWK()}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     issuer.IDToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":   issuer.Subject,
+			"email": issuer.Email,
+			"name":  "User",
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (s *stubIssuer) URL() string {
+	return s.server.URL
+}
+
+// sign signs the claims of an ID token with the key of the issuer, or with another one
+func (s *stubIssuer) sign(t *testing.T, key *SigningKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.PrivateKey)
+	require.NoError(t, err)
+	return signed
+}
+
+func (s *stubIssuer) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   s.URL(),
+		"sub":   s.Subject,
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "nonce",
+	}
+}
+
+func newStubProvider(t *testing.T, issuer *stubIssuer) RedirectProvider {
+	provider, err := NewOIDCProvider(context.Background(), config.AuthProvider{
+		Name:         "stub",
+		Type:         "oidc",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  "http://localhost/auth/stub/callback",
+		Issuer:       issuer.URL(),
+		Scopes:       []string{"email"},
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestOIDCProviderDiscovery(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := newStubProvider(t, issuer)
+
+	oauth2_config := provider.OAuth2Config()
+	assert.Equal(t, issuer.URL()+"/authorize", oauth2_config.Endpoint.AuthURL)
+	assert.Equal(t, issuer.URL()+"/token", oauth2_config.Endpoint.TokenURL)
+	assert.Equal(t, []string{"openid", "email"}, oauth2_config.Scopes)
+	assert.Equal(t, "stub", provider.Name())
+}
+
+func TestOIDCProviderMissingIssuer(t *testing.T) {
+	_, err := NewOIDCProvider(context.Background(), config.AuthProvider{Name: "stub", Type: "oidc"})
+	assert.Equal(t, ErrMissingIssuer, err)
+}
+
+func TestOIDCProviderFetchUser(t *testing.T) {
+	issuer := newStubIssuer(t)
+	provider := newStubProvider(t, issuer)
+	other_key, err := GenerateSigningKey("RS256")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		key   *SigningKey
+		claim func(claims jwt.MapClaims)
+		nonce string
+		valid bool
+	}{
+		{name: "valid", key: issuer.key, claim: func(jwt.MapClaims) {}, nonce: "nonce", valid: true},
+		{name: "other nonce", key: issuer.key, claim: func(jwt.MapClaims) {}, nonce: "other", valid: false},
+		{name: "missing nonce", key: issuer.key, claim: func(claims jwt.MapClaims) { delete(claims, "nonce") }, nonce: "nonce", valid: false},
+		{name: "other audience", key: issuer.key, claim: func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, nonce: "nonce", valid: false},
+		{name: "expired", key: issuer.key, claim: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, nonce: "nonce", valid: false},
+		{name: "other issuer", key: issuer.key, claim: func(claims jwt.MapClaims) { claims["iss"] = "https://elsewhere.example.com" }, nonce: "nonce", valid: false},
+		{name: "unknown key", key: other_key, claim: func(jwt.MapClaims) {}, nonce: "nonce", valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := issuer.claims()
+			test.claim(claims)
+			issuer.IDToken = issuer.sign(t, test.key, claims)
+
+			token, err := provider.OAuth2Config().Exchange(context.Background(), "code")
+			require.NoError(t, err)
+
+			user, err := provider.FetchUser(provider.Session(token, test.nonce))
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "subject", user.UserID)
+			assert.Equal(t, "user@example.com", user.Email)
+			assert.Equal(t, "stub", user.Provider)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gistsapp/api/types"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// MailOutbox queues the emails in the database, so that requests don't wait for the mail server,
+// and delivers them in the background through the actual transport.
+// It is itself a MailTransport, given to the EmailService in place of the actual one.
+type MailOutbox interface {
+	repositories.MailTransport
+	Start(ctx context.Context)
+	Status() (*types.OutboxStatus, error)
+	Retry(id string) error
+}
+
+type mailOutbox struct {
+	db        repositories.Database
+	transport repositories.MailTransport
+	config    config.MailOutboxConfig
+	wake      chan struct{}
+}
+
+func NewMailOutbox(db repositories.Database, transport repositories.MailTransport, config config.MailOutboxConfig) MailOutbox {
+	return &mailOutbox{
+		db:        db,
+		transport: transport,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Send queues the mail, and wakes a worker up so that it doesn't wait for the next poll
+func (o *mailOutbox) Send(mail *repositories.Mail) error {
+	ttl := time.Duration(0)
+	if !mail.ExpiresAt.IsZero() {
+		ttl = time.Until(mail.ExpiresAt)
+	}
+	_, err := o.db.CreateOutboxMail(&types.OutboxMail{
+		Sender:    mail.From,
+		Recipient: mail.To,
+		Subject:   mail.Subject,
+		HTML:      mail.HTML,
+		Text:      mail.Text,
+	}, ttl)
+	if err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the workers until ctx is done.
+// Workers of every instance share the outbox, a mail being claimed by a single worker at a time.
+func (o *mailOutbox) Start(ctx context.Context) {
+	for i := 0; i < o.config.Workers; i++ {
+		go o.work(ctx)
+	}
+}
+
+func (o *mailOutbox) Status() (*types.OutboxStatus, error) {
+	return o.db.GetOutboxStatus()
+}
+
+// Retry queues a dead letter again, with a fresh set of attempts
+func (o *mailOutbox) Retry(id string) error {
+	err := o.db.RetryOutboxMail(id)
+	if err == sql.ErrNoRows {
+		return ErrUnknownDeadLetter
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *mailOutbox) work(ctx context.Context) {
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		// keep draining while there are due mails, then wait for a new one or the next poll
+		for o.drain() {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain sends a batch of due mails, and tells if there may be more of them
+func (o *mailOutbox) drain() bool {
+	mails, err := o.db.ClaimOutboxMails(o.config.BatchSize, o.config.Lease)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	for _, mail := range mails {
+		o.deliver(mail)
+	}
+	return len(mails) == o.config.BatchSize
+}
+
+func (o *mailOutbox) deliver(mail *types.OutboxMail) {
+	if mail.ExpiresAt != nil {
+		expires_at, err := time.Parse(time.RFC3339, *mail.ExpiresAt)
+		if err == nil && time.Now().After(expires_at) {
+			o.kill(mail, ErrMailExpired.Error())
+			return
+		}
+	}
+
+	err := o.transport.Send(&repositories.Mail{
+		From:    mail.Sender,
+		To:      mail.Recipient,
+		Subject: mail.Subject,
+		HTML:    mail.HTML,
+		Text:    mail.Text,
+	})
+	if err == nil {
+		log.Info("Email sent to " + mail.Recipient)
+		if err := o.db.DeleteOutboxMail(mail.ID); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if mail.Attempts >= o.config.MaxAttempts {
+		o.kill(mail, err.Error())
+		return
+	}
+	delay := o.backoff(mail.Attempts)
+	log.Warn("Failed to send email to ", mail.Recipient, ", retrying in ", delay, ": ", err)
+	if err := o.db.RescheduleOutboxMail(mail.ID, delay, err.Error()); err != nil {
+		log.Error(err)
+	}
+}
+
+func (o *mailOutbox) kill(mail *types.OutboxMail, reason string) {
+	log.Error("Giving up on email ", mail.ID, " to ", mail.Recipient, ": ", reason)
+	if err := o.db.KillOutboxMail(mail.ID, reason); err != nil {
+		log.Error(err)
+	}
+}
+
+// backoff doubles the delay after every failed attempt, up to the configured maximum
+func (o *mailOutbox) backoff(attempts int) time.Duration {
+	delay := o.config.BaseDelay
+	for i := 1; i < attempts && delay < o.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxDelay {
+		delay = o.config.MaxDelay
+	}
+	return delay
+}
+
+var ErrUnknownDeadLetter error = errors.New("Unknown dead letter")
+var ErrMailExpired error = errors.New("Expired before it could be sent")
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gistsapp/api/auth/utils"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const PersonalAccessTokenPrefix = verifier.PersonalAccessTokenPrefix
+
+// PersonalAccessTokenService manages the tokens users mint to call the API from their scripts.
+// Only their hash is stored: a token is shown once, when it is created.
+type PersonalAccessTokenService interface {
+	Create(user_id string, name string, scopes []string, expires_at *time.Time) (*types.PersonalAccessToken, string, error)
+	List(user_id string) ([]*types.PersonalAccessToken, error)
+	Revoke(user_id string, id string) error
+	VerifyAccessToken(token string) (*types.JWTClaims, error)
+}
+
+type personalAccessTokenService struct {
+	database repositories.Database
+	hasher   TokenHasher
+	config   config.PersonalAccessTokensConfig
+}
+
+func NewPersonalAccessTokenService(database repositories.Database, hasher TokenHasher, config config.PersonalAccessTokensConfig) PersonalAccessTokenService {
+	return &personalAccessTokenService{
+		database: database,
+		hasher:   hasher,
+		config:   config,
+	}
+}
+
+// Create mints a token for the user, returning its value along with it.
+// The token expires at expires_at, or after the default lifetime when none is given, and never after the maximum lifetime.
+func (p *personalAccessTokenService) Create(user_id string, name string, scopes []string, expires_at *time.Time) (*types.PersonalAccessToken, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(p.config.Scopes, scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	now := time.Now()
+	expiration := now.Add(p.config.DefaultTTL)
+	if expires_at != nil {
+		expiration = *expires_at
+	}
+	if !expiration.After(now) || expiration.After(now.Add(p.config.MaxTTL)) {
+		return nil, "", ErrInvalidTokenExpiration
+	}
+
+	random, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	token_value := PersonalAccessTokenPrefix + random
+	token, err := p.database.CreatePersonalAccessToken(&types.PersonalAccessToken{
+		UserID:    user_id,
+		Name:      name,
+		Token:     p.hasher.Hash(token_value),
+		Scope:     strings.Join(scopes, " "),
+		ExpiresAt: expiration.Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return token, token_value, nil
+}
+
+func (p *personalAccessTokenService) List(user_id string) ([]*types.PersonalAccessToken, error) {
+	return p.database.GetPersonalAccessTokensByUserID(user_id)
+}
+
+func (p *personalAccessTokenService) Revoke(user_id string, id string) error {
+	err := p.database.DeleteUserPersonalAccessToken(user_id, id)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// VerifyAccessToken checks the token, and describes it with the claims an access token of its user would have, limited to its scope.
+// Having no session, it is verifier.Scoped.
+func (p *personalAccessTokenService) VerifyAccessToken(token_value string) (*types.JWTClaims, error) {
+	if !strings.HasPrefix(token_value, PersonalAccessTokenPrefix) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+	token, err := p.database.UsePersonalAccessToken(p.hasher.Hash(token_value))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	expires_at, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	created_at, err := time.Parse(time.RFC3339, token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &types.JWTClaims{
+		UserID: token.UserID,
+		Scope:  token.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        token.ID,
+			ExpiresAt: jwt.NewNumericDate(expires_at),
+			IssuedAt:  jwt.NewNumericDate(created_at),
+		},
+	}, nil
+}
+
+var ErrInvalidTokenExpiration error = errors.New("The expiration date must be in the future, within the maximum lifetime of the tokens")
+var ErrInvalidPersonalAccessToken error = errors.New("Invalid or expired personal access token")
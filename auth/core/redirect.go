@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/utils"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/openidConnect"
+	"golang.org/x/oauth2"
+	google_endpoint "golang.org/x/oauth2/google"
+)
+
+// how long a user has to log in with the provider once redirected to it
+const AuthFlowLifetime = time.Minute * 10
+
+// RedirectProvider is a provider users log in with through a redirect.
+// We build the authorization URL and exchange the code ourselves, rather than through goth, to use PKCE;
+// goth then only reads the user from the tokens obtained.
+type RedirectProvider interface {
+	goth.Provider
+	Client() *http.Client
+	OAuth2Config() *oauth2.Config
+	Session(token *oauth2.Token, nonce string) goth.Session // nonce is the one the ID token must carry, when the provider issues one
+}
+
+// AuthFlow is the state of a redirect login, kept by the browser in a short-lived signed cookie from the redirect to the callback
+type AuthFlow struct {
+	Provider  string `json:"provider"`
+	State     string `json:"state"`
+	Verifier  string `json:"verifier"` // PKCE code verifier, whose challenge was sent to the provider
+	Nonce     string `json:"nonce"`    // sent to the provider, which puts it in the ID token
+	ReturnTo  string `json:"return_to"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type githubProvider struct {
+	*github.Provider
+	config *oauth2.Config
+}
+
+func newGithubProvider(conf config.AuthProvider) RedirectProvider {
+	return &githubProvider{
+		Provider: github.New(conf.ClientID, conf.ClientSecret, conf.RedirectURI, conf.Scopes...),
+		config:   newOAuth2Config(conf, oauth2.Endpoint{AuthURL: github.AuthURL, TokenURL: github.TokenURL}, conf.Scopes),
+	}
+}
+
+func (p *githubProvider) OAuth2Config() *oauth2.Config {
+	return p.config
+}
+
+func (p *githubProvider) Session(token *oauth2.Token, _ string) goth.Session {
+	return &github.Session{AccessToken: token.AccessToken}
+}
+
+type googleProvider struct {
+	*google.Provider
+	config *oauth2.Config
+}
+
+func newGoogleProvider(conf config.AuthProvider) RedirectProvider {
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email"}
+	}
+	return &googleProvider{
+		Provider: google.New(conf.ClientID, conf.ClientSecret, conf.RedirectURI, scopes...),
+		config:   newOAuth2Config(conf, google_endpoint.Endpoint, scopes),
+	}
+}
+
+func (p *googleProvider) OAuth2Config() *oauth2.Config {
+	return p.config
+}
+
+func (p *googleProvider) Session(token *oauth2.Token, _ string) goth.Session {
+	id_token, _ := token.Extra("id_token").(string)
+	return &google.Session{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.Expiry,
+		IDToken:      id_token,
+	}
+}
+
+func (p *oidcProvider) OAuth2Config() *oauth2.Config {
+	return p.config
+}
+
+func (p *oidcProvider) Session(token *oauth2.Token, nonce string) goth.Session {
+	id_token, _ := token.Extra("id_token").(string)
+	return &oidcSession{
+		Session: &openidConnect.Session{
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			ExpiresAt:    token.Expiry,
+			IDToken:      id_token,
+		},
+		provider: p.Provider,
+		nonce:    nonce,
+	}
+}
+
+func newOAuth2Config(conf config.AuthProvider, endpoint oauth2.Endpoint, scopes []string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		RedirectURL:  conf.RedirectURI,
+		Endpoint:     endpoint,
+		Scopes:       scopes,
+	}
+}
+
+// BeginRedirect returns the URL of the provider to redirect the user to, along with the signed flow to keep in a cookie.
+// return_to is where the user lands once logged in, it must be allowed by the config.
+func (a *authService) BeginRedirect(provider string, return_to string) (string, string, error) {
+	redirect_provider, ok := a.providers[provider]
+	if !ok {
+		return "", "", UnkownProvider
+	}
+	if return_to == "" {
+		return_to = a.returnToConfig.Default
+	} else if !a.isAllowedReturnTo(return_to) {
+		return "", "", ErrInvalidReturnTo
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	flow := AuthFlow{
+		Provider:  provider,
+		State:     state,
+		Verifier:  oauth2.GenerateVerifier(),
+		Nonce:     nonce,
+		ReturnTo:  return_to,
+		ExpiresAt: time.Now().Add(AuthFlowLifetime).Unix(),
+	}
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return "", "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	auth_url := redirect_provider.OAuth2Config().AuthCodeURL(flow.State, oauth2.S256ChallengeOption(flow.Verifier), oauth2.SetAuthURLParam("nonce", flow.Nonce))
+	return auth_url, payload + "." + a.hasher.Hash("flow|"+payload), nil
+}
+
+// VerifyAuthFlow checks the flow cookie against the provider and the state the callback was called with
+func (a *authService) VerifyAuthFlow(signed_flow string, provider string, state string) (*AuthFlow, error) {
+	payload, signature, found := strings.Cut(signed_flow, ".")
+	if !found || !hmac.Equal([]byte(a.hasher.Hash("flow|"+payload)), []byte(signature)) {
+		return nil, ErrInvalidAuthFlow
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidAuthFlow
+	}
+	var flow AuthFlow
+	if err := json.Unmarshal(data, &flow); err != nil {
+		return nil, ErrInvalidAuthFlow
+	}
+
+	if flow.Provider != provider || time.Now().Unix() > flow.ExpiresAt {
+		return nil, ErrInvalidAuthFlow
+	}
+	if state == "" || !hmac.Equal([]byte(flow.State), []byte(state)) {
+		return nil, ErrInvalidAuthFlow
+	}
+	return &flow, nil
+}
+
+// completeRedirect exchanges the code along with the PKCE verifier of the flow, and reads the user from the tokens obtained
+func (a *authService) completeRedirect(flow *AuthFlow, code string) (goth.User, error) {
+	redirect_provider, ok := a.providers[flow.Provider]
+	if !ok {
+		return goth.User{}, UnkownProvider
+	}
+	if code == "" {
+		return goth.User{}, ErrCantCompleteAuth
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, redirect_provider.Client())
+	token, err := redirect_provider.OAuth2Config().Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return goth.User{}, err
+	}
+	user, err := redirect_provider.FetchUser(redirect_provider.Session(token, flow.Nonce))
+	if err != nil {
+		return goth.User{}, err
+	}
+	user.Provider = flow.Provider
+	user.UserID = identityID(flow.Provider, user.UserID)
+	return user, nil
+}
+
+// identityID namespaces the id a provider gives a user by the provider, the id of their identity:
+// a provider can't assert the ids of the users of the other providers, nor the emails of the local users
+func identityID(provider string, subject string) string {
+	return provider + ":" + subject
+}
+
+// isAllowedReturnTo tells if the URL is under one of the allowed ones, having the same origin and a path below theirs
+func (a *authService) isAllowedReturnTo(return_to string) bool {
+	target, err := url.Parse(return_to)
+	if err != nil || target.User != nil {
+		return false
+	}
+	for _, allowed := range a.returnToConfig.Allowed {
+		allowed_url, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if target.Scheme != allowed_url.Scheme || target.Host != allowed_url.Host {
+			continue
+		}
+		// https://app/gists allows https://app/gists/42, but not https://app/gists-elsewhere
+		if target.Path == allowed_url.Path || strings.HasPrefix(target.Path, strings.TrimSuffix(allowed_url.Path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+var ErrInvalidAuthFlow error = errors.New("Invalid or expired login, please try again")
+var ErrInvalidReturnTo error = errors.New("This return_to URL is not allowed")
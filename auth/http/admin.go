@@ -0,0 +1,337 @@
+package http
+
+import (
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/core"
+	"github.com/gistsapp/api/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AdminController interface {
+	ListSigningKeys() fiber.Handler
+	AddSigningKey() fiber.Handler
+	RetireSigningKey() fiber.Handler
+	RotateSigningKey() fiber.Handler
+	MailOutboxStatus() fiber.Handler
+	RetryMail() fiber.Handler
+	ListOAuthClients() fiber.Handler
+	RegisterOAuthClient() fiber.Handler
+	DeleteOAuthClient() fiber.Handler
+	Register(app *fiber.App)
+}
+
+type adminController struct {
+	jwtService  core.JWTService
+	mailOutbox  core.MailOutbox
+	oauthServer core.OAuthServer
+	config      *config.Config
+}
+
+func NewAdminController(jwtService core.JWTService, mailOutbox core.MailOutbox, oauthServer core.OAuthServer, config *config.Config) AdminController {
+	return adminController{
+		jwtService:  jwtService,
+		mailOutbox:  mailOutbox,
+		oauthServer: oauthServer,
+		config:      config,
+	}
+}
+
+// ListSigningKeys godoc
+//
+//	@Summary		List signing keys
+//	@Description	Use this endpoint to list the keys of the signing keyring that are not retired yet
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		http.HTTPSigningKey
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/admin/keys [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) ListSigningKeys() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		keys := []HTTPSigningKey{}
+		for _, key := range a.jwtService.SigningKeys() {
+			keys = append(keys, NewHTTPSigningKey(key))
+		}
+		return c.JSON(keys)
+	}
+}
+
+// AddSigningKey godoc
+//
+//	@Summary		Add a signing key
+//	@Description	Use this endpoint to add a key to the keyring, it is published right away and starts signing tokens at activates_at
+//	@Tags			admin
+//	@Param			key	body	http.SigningKeyValidator	false	"Key options"
+//	@Produce		json
+//	@Success		201	{object}	http.HTTPSigningKey
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/admin/keys [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) AddSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(SigningKeyValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		activates_at := time.Now()
+		if e.ActivatesAt != nil {
+			activates_at = *e.ActivatesAt
+		}
+
+		key, err := a.jwtService.AddSigningKey(e.Algorithm, activates_at)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(NewHTTPSigningKey(key))
+	}
+}
+
+// RetireSigningKey godoc
+//
+//	@Summary		Retire a signing key
+//	@Description	Use this endpoint to schedule the date after which a key no longer verifies tokens
+//	@Tags			admin
+//	@Param			kid			path	string							true	"Key ID"
+//	@Param			retirement	body	http.RetireSigningKeyValidator	false	"Retirement date"
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/admin/keys/{kid}/retire [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) RetireSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(RetireSigningKeyValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		retires_at := time.Now().Add(core.AccessTokenLifetime)
+		if e.RetiresAt != nil {
+			retires_at = *e.RetiresAt
+		}
+
+		err := a.jwtService.RetireSigningKey(c.Params("kid"), retires_at)
+		if err == core.ErrUnknownSigningKey {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Key retires at " + retires_at.Format(time.RFC3339),
+		})
+	}
+}
+
+// RotateSigningKey godoc
+//
+//	@Summary		Rotate the signing key
+//	@Description	Use this endpoint to promote a new signing key, which signs tokens once the verifiers caching the JWKS know it, the current one being retired once the tokens it signed have expired
+//	@Tags			admin
+//	@Param			key	body	http.SigningKeyValidator	false	"Key options, activates_at is ignored"
+//	@Produce		json
+//	@Success		201	{object}	http.HTTPSigningKey
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/admin/keys/rotate [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) RotateSigningKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(SigningKeyValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		key, err := a.jwtService.RotateSigningKey(e.Algorithm)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(NewHTTPSigningKey(key))
+	}
+}
+
+// MailOutboxStatus godoc
+//
+//	@Summary		Mail outbox status
+//	@Description	Use this endpoint to see how many emails are waiting to be sent, and the ones that were given up on
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	types.OutboxStatus
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/admin/mail/outbox [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) MailOutboxStatus() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		status, err := a.mailOutbox.Status()
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(status)
+	}
+}
+
+// RetryMail godoc
+//
+//	@Summary		Retry a dead letter
+//	@Description	Use this endpoint to queue an email that was given up on again
+//	@Tags			admin
+//	@Param			id	path	string	true	"Mail ID"
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/admin/mail/outbox/{id}/retry [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) RetryMail() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := a.mailOutbox.Retry(c.Params("id"))
+		if err == core.ErrUnknownDeadLetter {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Email queued",
+		})
+	}
+}
+
+// ListOAuthClients godoc
+//
+//	@Summary		List OAuth clients
+//	@Description	Use this endpoint to list the apps getting tokens from the auth service
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		http.HTTPOAuthClient
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/admin/oauth/clients [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) ListOAuthClients() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		clients, err := a.oauthServer.Clients()
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		http_clients := []HTTPOAuthClient{}
+		for _, client := range clients {
+			http_clients = append(http_clients, NewHTTPOAuthClient(client, ""))
+		}
+		return c.JSON(http_clients)
+	}
+}
+
+// RegisterOAuthClient godoc
+//
+//	@Summary		Register an OAuth client
+//	@Description	Use this endpoint to register an app getting tokens from the auth service, its secret is only shown in the response
+//	@Tags			admin
+//	@Param			client	body	http.OAuthClientValidator	true	"Client"
+//	@Produce		json
+//	@Success		201	{object}	http.HTTPOAuthClient
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/admin/oauth/clients [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) RegisterOAuthClient() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(OAuthClientValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		client, secret, err := a.oauthServer.RegisterClient(&core.ClientRegistration{
+			Name:         e.Name,
+			RedirectURIs: e.RedirectURIs,
+			GrantTypes:   e.GrantTypes,
+			Scopes:       e.Scopes,
+			Public:       e.Public,
+		})
+		if err == core.ErrInvalidRedirectURI || err == core.ErrPublicClientCredentials || err == core.ErrUnsupportedGrantType || err == core.ErrInvalidScope {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(NewHTTPOAuthClient(client, secret))
+	}
+}
+
+// DeleteOAuthClient godoc
+//
+//	@Summary		Delete an OAuth client
+//	@Description	Use this endpoint to delete an OAuth client, the sessions it opened end along with it
+//	@Tags			admin
+//	@Param			id	path	string	true	"Client ID"
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/admin/oauth/clients/{id} [delete]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a adminController) DeleteOAuthClient() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := a.oauthServer.DeleteClient(c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Client deleted",
+		})
+	}
+}
+
+func (a adminController) Register(app *fiber.App) {
+	admin := app.Group("/admin", AdminMiddleware(a.config.Admin))
+	admin.Get("/keys", a.ListSigningKeys())
+	admin.Post("/keys", a.AddSigningKey())
+	admin.Post("/keys/rotate", a.RotateSigningKey())
+	admin.Post("/keys/:kid/retire", a.RetireSigningKey())
+	admin.Get("/mail/outbox", a.MailOutboxStatus())
+	admin.Post("/mail/outbox/:id/retry", a.RetryMail())
+	admin.Get("/oauth/clients", a.ListOAuthClients())
+	admin.Post("/oauth/clients", a.RegisterOAuthClient())
+	admin.Delete("/oauth/clients/:id", a.DeleteOAuthClient())
+}
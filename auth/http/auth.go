@@ -1,9 +1,14 @@
 package http
 
 import (
+	"net/url"
+	"strconv"
+	"time"
+
 	"github.com/gistsapp/api/auth/config"
 	"github.com/gistsapp/api/auth/core"
 	"github.com/gistsapp/api/auth/utils"
+	"github.com/gistsapp/api/types"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 )
@@ -13,8 +18,15 @@ type AuthController interface {
 	Authenticate() fiber.Handler //done
 	LocalAuth() fiber.Handler
 	VerifyAuthToken() fiber.Handler
+	MagicLink() fiber.Handler
 	Renew() fiber.Handler
 	Logout() fiber.Handler
+	LogoutEverywhere() fiber.Handler
+	Sessions() fiber.Handler
+	RevokeSession() fiber.Handler
+	CreatePersonalAccessToken() fiber.Handler
+	PersonalAccessTokens() fiber.Handler
+	RevokePersonalAccessToken() fiber.Handler
 	Register(app *fiber.App)
 	Introspect() fiber.Handler
 }
@@ -22,30 +34,69 @@ type AuthController interface {
 type authController struct {
 	service    core.AuthService
 	jwtService core.JWTService
+	pats       core.PersonalAccessTokenService
 	config     *config.Config
 }
 
-func NewAuthController(service core.AuthService, config *config.Config, jwtService core.JWTService) AuthController {
+func NewAuthController(service core.AuthService, config *config.Config, jwtService core.JWTService, pats core.PersonalAccessTokenService) AuthController {
 	return authController{
 		service:    service,
 		config:     config,
 		jwtService: jwtService,
+		pats:       pats,
 	}
 }
 
 // Callback godoc
 //
 //	@Summary		OAuth2 Callback
-//	@Description	Use this endpoint to complete the OAuth2 flow
+//	@Description	Use this endpoint to complete the OAuth2 flow, started in the same browser with /auth/{provider}
 //	@Tags			auth
 //	@Produce		json
-//	@Success		200	{object}	http.HTTPMessage
+//	@Success		302	{string}	redirect to the return_to URL of the flow
 //	@Failure		404	{object}	http.HTTPErrorMessage
 //	@Failure		400	{object}	http.HTTPErrorMessage
 //	@Router			/auth/{provider}/callback [get]
+//	@Param			provider	path	string	true	"Provider name"
+//	@Param			state		query	string	true	"State of the flow"
+//	@Param			code		query	string	true	"Authorization code"
 func (a authController) Callback() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		token, err := a.service.Callback(c)
+		// the flow cookie is single use, whatever the outcome
+		c.Cookie(utils.ClearCookie("auth_flow", a.config.Keycloak.Realm, &a.config.Cookies))
+		flow, err := a.service.VerifyAuthFlow(c.Cookies("auth_flow"), c.Params("provider"), c.Query("state"))
+		if err != nil {
+			log.Warnw("Security event: callback without a matching login flow", "provider", c.Params("provider"), "ip", c.IP())
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if provider_error := c.Query("error"); provider_error != "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: "Couldn't complete auth: " + provider_error,
+			})
+		}
+
+		if link_intent := c.Cookies("link_intent"); link_intent != "" {
+			c.Cookie(utils.ClearCookie("link_intent", a.config.Keycloak.Realm, &a.config.Cookies))
+			_, err := a.service.Link(flow, c.Query("code"), link_intent)
+			if err == core.ErrIdentityAlreadyLinked {
+				return c.Status(fiber.StatusConflict).JSON(HTTPErrorMessage{
+					Error: err.Error(),
+				})
+			}
+			if err == nil {
+				return c.Redirect(flow.ReturnTo)
+			}
+			if err != core.ErrInvalidLinkIntent {
+				return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+					Error: "Couldn't complete auth",
+				})
+			}
+			// a stale intent, or one for another provider, doesn't prevent logging in
+		}
+
+		token, err := a.service.Callback(flow, c.Query("code"), core.NewClientInfo(c))
 		if err == core.UnkownProvider {
 			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
 				Error: "Unkown provider",
@@ -58,7 +109,7 @@ func (a authController) Callback() fiber.Handler {
 		}
 		c.Cookie(utils.Cookie("access_token", token.AccessToken, &a.config.Cookies))
 		c.Cookie(utils.Cookie("refresh_token", token.RefreshToken, &a.config.Cookies))
-		return c.Redirect(a.config.Keycloak.RedirectURI)
+		return c.Redirect(flow.ReturnTo)
 	}
 }
 
@@ -72,10 +123,38 @@ func (a authController) Callback() fiber.Handler {
 //	@Failure		400	{object}	http.HTTPErrorMessage
 //	@Router			/auth/{provider} [get]
 //	@Param			provider	path	string	true	"Provider name"
+//	@Param			return_to	query	string	false	"Where to land once logged in, among the allowed URLs"
 func (a authController) Authenticate() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return a.service.AuthenticateWithRedirect(c)
+		return a.beginRedirect(c)
+	}
+}
+
+// beginRedirect keeps the state and PKCE verifier of a new login flow in a cookie, and redirects to the provider
+func (a authController) beginRedirect(c *fiber.Ctx) error {
+	auth_url, flow, err := a.service.BeginRedirect(c.Params("provider"), c.Query("return_to"))
+	if err == core.UnkownProvider {
+		return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+			Error: "Unkown provider",
+		})
+	}
+	if err == core.ErrInvalidReturnTo {
+		return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+			Error: err.Error(),
+		})
 	}
+	if err != nil {
+		return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+			Error: err.Error(),
+		})
+	}
+
+	flow_cookie := utils.Cookie("auth_flow", flow, &a.config.Cookies)
+	flow_cookie.HTTPOnly = true
+	flow_cookie.SameSite = fiber.CookieSameSiteLaxMode // sent back along with the top-level redirect from the provider
+	flow_cookie.Expires = time.Now().Add(core.AuthFlowLifetime)
+	c.Cookie(flow_cookie)
+	return c.Redirect(auth_url)
 }
 
 // LocalAuth godoc
@@ -87,6 +166,7 @@ func (a authController) Authenticate() fiber.Handler {
 //		@Produce		json
 //		@Success			200 {object} http.HTTPMessage
 //		@Failure		400	{object}	http.HTTPErrorMessage
+//		@Failure		429	{object}	http.HTTPErrorMessage
 //		@Router			/auth/local/begin [post]
 func (a authController) LocalAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -96,12 +176,38 @@ func (a authController) LocalAuth() fiber.Handler {
 				Error: err.Error(),
 			})
 		}
-		if _, err := a.service.AuthenticateWithCode(e.Email); err != nil {
+		// the nonce binds the magic link to this browser
+		nonce := ""
+		if a.config.MagicLink.Enabled {
+			var err error
+			nonce, err = utils.RandomToken(32)
+			if err != nil {
+				return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+					Error: err.Error(),
+				})
+			}
+		}
+
+		_, err := a.service.AuthenticateWithCode(e.Email, nonce, c.Get(fiber.HeaderAcceptLanguage))
+		if err == core.ErrCodeCooldown {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(a.config.VerificationCode.Cooldown.Seconds())))
+			return c.Status(fiber.StatusTooManyRequests).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
 			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
 				Error: err.Error(),
 			})
 		}
 
+		if nonce != "" {
+			nonce_cookie := utils.Cookie("magic_link_nonce", nonce, &a.config.Cookies)
+			nonce_cookie.HTTPOnly = true
+			nonce_cookie.Expires = time.Now().Add(a.config.MagicLink.TTL)
+			c.Cookie(nonce_cookie)
+		}
+
 		return c.JSON(HTTPMessage{
 			Message: "Hey check your emails :)",
 		})
@@ -129,7 +235,7 @@ func (a authController) VerifyAuthToken() fiber.Handler {
 		}
 
 		log.Info(a.service)
-		tokens, err := a.service.VerifyAuthToken(e.Token, e.Email)
+		tokens, err := a.service.VerifyAuthToken(e.Token, e.Email, core.NewClientInfo(c))
 
 		if err != nil {
 			return c.Status(fiber.ErrUnauthorized.Code).JSON(HTTPErrorMessage{
@@ -149,20 +255,64 @@ func (a authController) VerifyAuthToken() fiber.Handler {
 	}
 }
 
+// MagicLink godoc
+//
+//	@Summary		Authenticate with a magic link
+//	@Description	Use this endpoint to complete the authentication from the link sent by email, it only works in the browser that started the authentication
+//	@Tags			auth
+//	@Param			token	query	string	true	"Magic link token"
+//	@Produce		json
+//	@Success		302	{string}	redirect to the client app
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/auth/local/magic [get]
+func (a authController) MagicLink() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		nonce := c.Cookies("magic_link_nonce")
+		tokens, err := a.service.VerifyMagicLink(c.Query("token"), nonce, core.NewClientInfo(c))
+		if err != nil {
+			return c.Status(fiber.ErrUnauthorized.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		c.Cookie(utils.ClearCookie("magic_link_nonce", a.config.Keycloak.Realm, &a.config.Cookies))
+		c.Cookie(utils.Cookie("access_token", tokens.AccessToken, &a.config.Cookies))
+		c.Cookie(utils.Cookie("refresh_token", tokens.RefreshToken, &a.config.Cookies))
+		return c.Redirect(a.config.ReturnTo.Default)
+	}
+}
+
 // Renew godoc
 //
 //	@Summary		Renew access token
-//	@Description	Use this endpoint to renew access token
+//	@Description	Use this endpoint to exchange a refresh token, read from the body or the refresh token cookie, for a new pair of tokens. The refresh token can only be used once.
 //	@Tags			auth
+//	@Param			token	body	http.AuthRenewValidator	false	"Refresh token, defaults to the cookie"
 //	@Produce		json
 //	@Success			200 {object} http.HTTPTokens
 //	@Failure		400	{object}	http.HTTPErrorMessage
-//	@Router			/auth/renew [get]
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Router			/auth/renew [post]
 func (a authController) Renew() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		access_token := c.Locals("access_token").(string)
+		e := new(AuthRenewValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		refresh_token := e.RefreshToken
+		if refresh_token == "" {
+			refresh_token = c.Cookies(utils.CookieName("refresh_token", &a.config.Cookies))
+		}
+		if refresh_token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: "Missing refresh token",
+			})
+		}
 
-		tokens, err := a.service.Renew(access_token)
+		tokens, err := a.service.Renew(refresh_token, core.NewClientInfo(c))
 
 		if err != nil {
 			return c.Status(fiber.ErrUnauthorized.Code).JSON(fiber.Map{
@@ -183,7 +333,7 @@ func (a authController) Renew() fiber.Handler {
 // Logout godoc
 //
 //	@Summary		Logout
-//	@Description	Use this endpoint to logout (clear cookies)
+//	@Description	Use this endpoint to logout: the session is revoked server side and the cookies are cleared
 //	@Tags			auth
 //	@Produce		json
 //	@Success			302 {string} redirect to the client app
@@ -191,9 +341,89 @@ func (a authController) Renew() fiber.Handler {
 //	@Router			/auth/logout [get]
 func (a authController) Logout() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		access_token := AccessToken(c, &a.config.Cookies)
+		refresh_token := c.Cookies(utils.CookieName("refresh_token", &a.config.Cookies))
+		if err := a.service.Logout(access_token, refresh_token); err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		c.Cookie(utils.ClearCookie("access_token", a.config.Keycloak.Realm, &a.config.Cookies))
+		c.Cookie(utils.ClearCookie("refresh_token", a.config.Keycloak.Realm, &a.config.Cookies))
+		return c.Redirect(a.config.ReturnTo.Default)
+	}
+}
+
+// LogoutEverywhere godoc
+//
+//	@Summary		Logout everywhere
+//	@Description	Use this endpoint to revoke every session of the authenticated user, on every device
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/logout/all [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) LogoutEverywhere() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		if err := a.service.LogoutEverywhere(user_id); err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
 		c.Cookie(utils.ClearCookie("access_token", a.config.Keycloak.Realm, &a.config.Cookies))
 		c.Cookie(utils.ClearCookie("refresh_token", a.config.Keycloak.Realm, &a.config.Cookies))
-		return c.Redirect(a.config.Keycloak.RedirectURI)
+		return c.JSON(HTTPMessage{
+			Message: "Logged out everywhere",
+		})
+	}
+}
+
+// BackchannelLogout godoc
+//
+//	@Summary		Keycloak back-channel logout
+//	@Description	Keycloak calls this endpoint when a user logs out of it, which ends every session of the user
+//	@Tags			auth
+//	@Accept			x-www-form-urlencoded
+//	@Param			logout_token	formData	string	true	"Logout token"
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/auth/keycloak/backchannel-logout [post]
+func (a authController) BackchannelLogout() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		e := new(BackchannelLogoutValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		err := a.service.BackchannelLogout(e.LogoutToken)
+		if err == core.UnkownProvider {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Keycloak is not enabled",
+			})
+		}
+		if err == core.ErrInvalidLogoutToken {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Logged out",
+		})
 	}
 }
 
@@ -224,13 +454,310 @@ func (a authController) Introspect() fiber.Handler {
 		return nil
 	}
 }
+// Sessions godoc
+//
+//	@Summary		List sessions
+//	@Description	Use this endpoint to list the devices the authenticated user is logged in from
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{array}		types.Session
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/sessions [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) Sessions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		sessions, err := a.service.Sessions(user_id)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		for _, session := range sessions {
+			session.Current = session.ID == c.Locals("sessionID").(string)
+		}
+		return c.JSON(sessions)
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revoke a session
+//	@Description	Use this endpoint to log the authenticated user out of one of their sessions
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/sessions/{id} [delete]
+//	@Param			id				path	string	true	"Session ID"
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) RevokeSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		err := a.service.RevokeSession(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Session not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Session revoked",
+		})
+	}
+}
+
+// Link godoc
+//
+//	@Summary		Link a provider
+//	@Description	Use this endpoint to log in with another provider, whose identity is then linked to the authenticated user, who can log in with it afterwards
+//	@Tags			auth
+//	@Produce		json
+//	@Success		302	{string}	redirect to the provider
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/auth/link/{provider} [get]
+//	@Param			provider		path	string	true	"Provider name"
+//	@Param			return_to		query	string	false	"Where to land once linked, among the allowed URLs"
+//	@Param			Authorization	header	string	false	"Authorization, read from the access token cookie otherwise"
+func (a authController) Link() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// reached by navigating to it, so the access token usually comes from the cookie
+		claims, err := a.service.IsAuthenticated(AccessToken(c, &a.config.Cookies))
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(HTTPErrorMessage{
+				Error: "Invalid or expired JWT",
+			})
+		}
+
+		link_intent, err := a.service.LinkIntent(claims.UserID, c.Params("provider"))
+		if err == core.UnkownProvider {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Unkown provider",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		link_cookie := utils.Cookie("link_intent", link_intent, &a.config.Cookies)
+		link_cookie.HTTPOnly = true
+		link_cookie.SameSite = fiber.CookieSameSiteLaxMode
+		link_cookie.Expires = time.Now().Add(core.LinkIntentLifetime)
+		c.Cookie(link_cookie)
+		return a.beginRedirect(c)
+	}
+}
+
+// Identities godoc
+//
+//	@Summary		List identities
+//	@Description	Use this endpoint to list the providers the authenticated user can log in with
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{array}		http.HTTPIdentity
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/identities [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) Identities() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		federated_identities, err := a.service.Identities(user_id)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		identities := []HTTPIdentity{}
+		for _, federated_identity := range federated_identities {
+			identities = append(identities, NewHTTPIdentity(federated_identity))
+		}
+		return c.JSON(identities)
+	}
+}
+
+// Unlink godoc
+//
+//	@Summary		Unlink an identity
+//	@Description	Use this endpoint to stop logging in with one of the identities of the authenticated user, who must keep at least one
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		409	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/identities/{id} [delete]
+//	@Param			id				path	string	true	"Identity ID"
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) Unlink() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		id, err := url.PathUnescape(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		err = a.service.Unlink(user_id, id)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Identity not found",
+			})
+		}
+		if err == core.ErrLastIdentity {
+			return c.Status(fiber.StatusConflict).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Identity unlinked",
+		})
+	}
+}
+
+// CreatePersonalAccessToken godoc
+//
+//	@Summary		Create a personal access token
+//	@Description	Use this endpoint to mint a token the authenticated user can call the API with from their scripts, as a bearer token.
+//	@Description	The token is only shown in this response.
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			token	body		http.PersonalAccessTokenValidator	true	"Name, scopes and expiration date of the token"
+//	@Success		201		{object}	http.HTTPPersonalAccessToken
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/auth/tokens [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) CreatePersonalAccessToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(PersonalAccessTokenValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		token, token_value, err := a.pats.Create(user_id, e.Name, e.Scopes, e.ExpiresAt)
+		if err == core.ErrInvalidScope || err == core.ErrInvalidTokenExpiration {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(NewHTTPPersonalAccessToken(token, token_value))
+	}
+}
+
+// PersonalAccessTokens godoc
+//
+//	@Summary		List personal access tokens
+//	@Description	Use this endpoint to list the personal access tokens of the authenticated user, without their value
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{array}		http.HTTPPersonalAccessToken
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/tokens [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) PersonalAccessTokens() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		tokens, err := a.pats.List(user_id)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		personal_access_tokens := []HTTPPersonalAccessToken{}
+		for _, token := range tokens {
+			personal_access_tokens = append(personal_access_tokens, NewHTTPPersonalAccessToken(token, ""))
+		}
+		return c.JSON(personal_access_tokens)
+	}
+}
+
+// RevokePersonalAccessToken godoc
+//
+//	@Summary		Revoke a personal access token
+//	@Description	Use this endpoint to revoke one of the personal access tokens of the authenticated user, which can't be used anymore
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/auth/tokens/{id} [delete]
+//	@Param			id				path	string	true	"Token ID"
+//	@Param			Authorization	header	string	true	"Authorization"
+func (a authController) RevokePersonalAccessToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		err := a.pats.Revoke(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Token not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Token revoked",
+		})
+	}
+}
+
 func (a authController) Register(app *fiber.App) {
 	app.Post("/auth/local/begin", a.LocalAuth())
 	app.Post("/auth/local/verify", a.VerifyAuthToken())
-	app.Get("/auth/renew", a.Renew())
+	app.Get("/auth/local/magic", a.MagicLink())
+	app.Post("/auth/renew", a.Renew())
 	app.Get("/auth/logout", a.Logout())
-	protected := app.Group("/auth", JWTMiddleware(a.jwtService))
-	protected.Get("/me", a.Introspect())
+	// the middleware is set on each route: as a group middleware it would also catch /auth/:provider
+	jwt_middleware := JWTMiddleware(a.jwtService, a.pats, &a.config.Cookies)
+	// a personal access token can't manage the account, nor mint other tokens
+	session_only := SessionOnly()
+	protected := app.Group("/auth")
+	protected.Get("/me", jwt_middleware, session_only, a.Introspect())
+	protected.Post("/logout/all", jwt_middleware, session_only, a.LogoutEverywhere())
+	protected.Get("/sessions", jwt_middleware, session_only, a.Sessions())
+	protected.Delete("/sessions/:id", jwt_middleware, session_only, a.RevokeSession())
+	protected.Get("/identities", jwt_middleware, session_only, a.Identities())
+	protected.Delete("/identities/:id", jwt_middleware, session_only, a.Unlink())
+	protected.Post("/tokens", jwt_middleware, session_only, a.CreatePersonalAccessToken())
+	protected.Get("/tokens", jwt_middleware, session_only, a.PersonalAccessTokens())
+	protected.Delete("/tokens/:id", jwt_middleware, session_only, a.RevokePersonalAccessToken())
+	app.Get("/auth/link/:provider", a.Link())
+	app.Post("/auth/keycloak/backchannel-logout", a.BackchannelLogout())
 	app.Get("/auth/:provider/callback", a.Callback())
 	app.Get("/auth/:provider", a.Authenticate())
 }
@@ -1,6 +1,9 @@
 package http
 
 import (
+	"strings"
+	"time"
+
 	"github.com/gistsapp/api/auth/core"
 	"github.com/gistsapp/api/types"
 	"github.com/gofiber/fiber/v2"
@@ -29,6 +32,90 @@ type HTTPUserIntrospection struct {
 	FederatedIdentity *types.FederatedIdentity `json:"federated_identity"`
 }
 
+// HTTPIdentity is a federated identity, without the data the provider returned
+type HTTPIdentity struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+}
+
+func NewHTTPIdentity(federated_identity *types.FederatedIdentity) HTTPIdentity {
+	return HTTPIdentity{
+		ID:       federated_identity.ID,
+		Provider: federated_identity.Provider,
+	}
+}
+
+// HTTPOAuthError is an error of the OAuth protocol, as the clients expect it
+type HTTPOAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type HTTPOAuthClient struct {
+	ID           string   `json:"client_id"`
+	Secret       string   `json:"client_secret,omitempty"` // only shown once, when the client is registered
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+	CreatedAt    string   `json:"created_at"`
+}
+
+func NewHTTPOAuthClient(client *types.OAuthClient, secret string) HTTPOAuthClient {
+	return HTTPOAuthClient{
+		ID:           client.ID,
+		Secret:       secret,
+		Name:         client.Name,
+		Public:       client.Secret == nil,
+		RedirectURIs: strings.Fields(client.RedirectURIs),
+		GrantTypes:   strings.Fields(client.GrantTypes),
+		Scopes:       strings.Fields(client.Scopes),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// HTTPPersonalAccessToken is a personal access token, whose value is only shown once, when it is created
+type HTTPPersonalAccessToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Token      string   `json:"token,omitempty"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+}
+
+func NewHTTPPersonalAccessToken(token *types.PersonalAccessToken, token_value string) HTTPPersonalAccessToken {
+	return HTTPPersonalAccessToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Token:      token_value,
+		Scopes:     strings.Fields(token.Scope),
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+type HTTPSigningKey struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiresAt   *time.Time `json:"retires_at,omitempty"`
+}
+
+func NewHTTPSigningKey(key *core.SigningKey) HTTPSigningKey {
+	return HTTPSigningKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		CreatedAt:   key.CreatedAt,
+		ActivatesAt: key.ActivatesAt,
+		RetiresAt:   key.RetiresAt,
+	}
+}
+
 type handler struct {
 	jwtService core.JWTService
 }
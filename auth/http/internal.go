@@ -0,0 +1,115 @@
+package http
+
+import (
+	"github.com/gistsapp/api/auth/core"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// scopes of the internal endpoints, given to the service clients that need them
+const (
+	ScopeUsersRead        = "users:read"
+	ScopeTokensIntrospect = "tokens:introspect"
+)
+
+// InternalController serves our other services, authenticated with client credentials
+type InternalController interface {
+	ResolveUser() fiber.Handler
+	Introspect() fiber.Handler
+	Register(app *fiber.App)
+}
+
+type internalController struct {
+	userService core.UserService
+	jwtService  core.JWTService
+	pats        core.PersonalAccessTokenService
+}
+
+func NewInternalController(userService core.UserService, jwtService core.JWTService, pats core.PersonalAccessTokenService) InternalController {
+	return internalController{
+		userService: userService,
+		jwtService:  jwtService,
+		pats:        pats,
+	}
+}
+
+// ResolveUser godoc
+//
+//	@Summary		Resolve a user
+//	@Description	Use this endpoint to get a user from another service, with a service token granted the users:read scope
+//	@Tags			internal
+//	@Param			id	path	string	true	"User ID"
+//	@Produce		json
+//	@Success		200	{object}	types.User
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Router			/internal/users/{id} [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (i internalController) ResolveUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := uuid.Parse(c.Params("id")); err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: types.ErrNotFound.Error(),
+			})
+		}
+		user, err := i.userService.GetUserByID(c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(user)
+	}
+}
+
+// Introspect godoc
+//
+//	@Summary		Introspect a token
+//	@Description	Use this endpoint to check a token another service can't verify on its own, like a personal access token, with a service token granted the tokens:introspect scope.
+//	@Description	Revoked and expired tokens are inactive, and come without claims.
+//	@Tags			internal
+//	@Accept			x-www-form-urlencoded
+//	@Param			token	formData	string	true	"Token"
+//	@Produce		json
+//	@Success		200	{object}	verifier.Introspection
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Router			/internal/tokens/introspect [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (i internalController) Introspect() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.FormValue("token")
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: "Missing token",
+			})
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		claims, err := verifier.WithPersonalAccessTokens(i.jwtService, i.pats).VerifyAccessToken(token)
+		if err != nil {
+			return c.JSON(verifier.Introspection{
+				Active: false,
+			})
+		}
+		return c.JSON(verifier.Introspection{
+			Active:    true,
+			JWTClaims: claims,
+		})
+	}
+}
+
+func (i internalController) Register(app *fiber.App) {
+	internal := app.Group("/internal", ServiceMiddleware(i.jwtService))
+	internal.Get("/users/:id", verifier.RequireScopes(ScopeUsersRead), i.ResolveUser())
+	internal.Post("/tokens/introspect", verifier.RequireScopes(ScopeTokensIntrospect), i.Introspect())
+}
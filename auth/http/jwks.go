@@ -0,0 +1,37 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/gistsapp/api/auth/core"
+	"github.com/gofiber/fiber/v2"
+)
+
+type JWKSHandler struct {
+	jwtService core.JWTService
+}
+
+func NewJWKSHandler(jwtService core.JWTService) JWKSHandler {
+	return JWKSHandler{
+		jwtService: jwtService,
+	}
+}
+
+// JWKS godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Use this endpoint to get the public keys needed to verify access tokens
+//	@Tags			keys
+//	@Produce		json
+//	@Success		200	{object}	types.JWKS
+//	@Router			/.well-known/jwks.json [get]
+func (j JWKSHandler) JWKS() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(core.JWKSCacheTTL.Seconds())))
+		return c.JSON(j.jwtService.JWKS())
+	}
+}
+
+func (j JWKSHandler) Register(app *fiber.App) {
+	app.Get("/.well-known/jwks.json", j.JWKS())
+}
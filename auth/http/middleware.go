@@ -1,36 +1,86 @@
 package http
 
 import (
+	"crypto/subtle"
 	"strings"
 
+	"github.com/gistsapp/api/auth/config"
 	"github.com/gistsapp/api/auth/core"
+	"github.com/gistsapp/api/auth/utils"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
 	"github.com/gofiber/fiber/v2"
 )
 
-func JWTMiddleware(jwtService core.JWTService) fiber.Handler {
+// JWTMiddleware authenticates users with their access token, or with one of their personal access tokens,
+// read from the Authorization header or from the access token cookie
+func JWTMiddleware(jwtService core.JWTService, pats core.PersonalAccessTokenService, cookies *config.CookiesConfig) fiber.Handler {
+	return verifier.Middleware(verifier.WithPersonalAccessTokens(jwtService, pats), utils.CookieName("access_token", cookies))
+}
+
+// SessionOnly keeps the tokens that aren't tied to a login session on our own apps out of the endpoints managing the account:
+// personal access tokens, and the tokens issued to OAuth clients whatever their scope, though these are tied to a session of the client.
+// To be used after JWTMiddleware.
+func SessionOnly() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		header := c.Get("Authorization")
-		if header == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or malformed JWT"})
+		if verifier.Scoped(c.Locals("claims").(*types.JWTClaims)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the tokens of a login session can be used here, log in instead"})
 		}
+		return c.Next()
+	}
+}
 
-		bearer := strings.Split(header, " ")
-		if len(bearer) != 2 {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or malformed JWT"})
-		}
-		token := bearer[1]
-		if token == "" {
+// ServiceMiddleware only lets through the access tokens issued to services through client credentials,
+// which act for no user. The client and its scopes are kept for verifier.RequireScopes.
+func ServiceMiddleware(jwtService core.JWTService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !found || token == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or malformed JWT"})
 		}
 
 		claims, err := jwtService.VerifyAccessToken(token)
-		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired JWT"})
+		if err != nil || claims.UserID != "" || claims.ClientID == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired service token"})
 		}
 
-		c.Locals("userID", claims.UserID)
-		c.Locals("access_token", token)
+		c.Locals("clientID", claims.ClientID)
+		c.Locals("scope", claims.Scope)
 		return c.Next()
 	}
 }
 
+// AccessToken reads the access token from the Authorization header, or from the access token cookie
+func AccessToken(c *fiber.Ctx, cookies *config.CookiesConfig) string {
+	return verifier.AccessToken(c, utils.CookieName("access_token", cookies))
+}
+
+// SessionClaims returns the claims of the login session on our own apps, only read from the access token cookie:
+// tokens sent in the Authorization header come from clients or scripts, and neither these nor the Scoped ones stand for the user in a browser.
+// It returns nil when there is no such session.
+func SessionClaims(c *fiber.Ctx, tokens verifier.Verifier, cookies *config.CookiesConfig) *types.JWTClaims {
+	token := c.Cookies(utils.CookieName("access_token", cookies))
+	if token == "" {
+		return nil
+	}
+	claims, err := tokens.VerifyAccessToken(token)
+	if err != nil || claims.UserID == "" || verifier.Scoped(claims) {
+		return nil
+	}
+	return claims
+}
+
+// AdminMiddleware guards the admin endpoints with the configured admin token
+func AdminMiddleware(conf config.AdminConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if conf.Token == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Admin endpoints are disabled"})
+		}
+
+		token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(conf.Token)) != 1 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid admin token"})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// claimsVerifier accepts the tokens it knows, with their claims
+type claimsVerifier map[string]*types.JWTClaims
+
+func (v claimsVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return claims, nil
+}
+
+func TestSessionOnly(t *testing.T) {
+	tokens := claimsVerifier{
+		"session":         {UserID: "user", SessionID: "session"},
+		"client":          {UserID: "user", SessionID: "client-session", ClientID: "client", Scope: "openid gists:read"},
+		"personal":        {UserID: "user", Scope: "gists:read gists:write"},
+		"client-no-scope": {UserID: "user", SessionID: "client-session", ClientID: "client"},
+	}
+	app := fiber.New()
+	app.Get("/account", verifier.Middleware(tokens, "access_token"), SessionOnly(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{token: "session", status: fiber.StatusOK},
+		{token: "client", status: fiber.StatusForbidden},
+		{token: "client-no-scope", status: fiber.StatusForbidden},
+		{token: "personal", status: fiber.StatusForbidden},
+		{token: "unknown", status: fiber.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			request := httptest.NewRequest(fiber.MethodGet, "/account", nil)
+			request.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.token)
+			response, err := app.Test(request)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, response.StatusCode)
+		})
+	}
+}
+
+func TestSessionClaims(t *testing.T) {
+	tokens := claimsVerifier{
+		"session":  {UserID: "user", SessionID: "session"},
+		"client":   {UserID: "user", SessionID: "client-session", ClientID: "client", Scope: "openid"},
+		"personal": {UserID: "user", Scope: "gists:read"},
+		"service":  {ClientID: "service", Scope: "users:read"},
+	}
+	app := fiber.New()
+	app.Get("/authorize", func(c *fiber.Ctx) error {
+		if SessionClaims(c, tokens, &config.CookiesConfig{}) == nil {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		cookie string
+		bearer string
+		status int
+	}{
+		{name: "session cookie", cookie: "session", status: fiber.StatusOK},
+		{name: "client cookie", cookie: "client", status: fiber.StatusUnauthorized},
+		{name: "personal cookie", cookie: "personal", status: fiber.StatusUnauthorized},
+		{name: "service cookie", cookie: "service", status: fiber.StatusUnauthorized},
+		{name: "unknown cookie", cookie: "unknown", status: fiber.StatusUnauthorized},
+		{name: "session bearer", bearer: "session", status: fiber.StatusUnauthorized},
+		{name: "nothing", status: fiber.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(fiber.MethodGet, "/authorize", nil)
+			if test.cookie != "" {
+				request.Header.Set(fiber.HeaderCookie, "access_token="+test.cookie)
+			}
+			if test.bearer != "" {
+				request.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.bearer)
+			}
+			response, err := app.Test(request)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, response.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/gistsapp/api/auth/config"
+	"github.com/gistsapp/api/auth/core"
+	"github.com/gistsapp/api/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type OAuthController interface {
+	Authorize() fiber.Handler
+	Token() fiber.Handler
+	UserInfo() fiber.Handler
+	Discovery() fiber.Handler
+	Register(app *fiber.App)
+}
+
+type oauthController struct {
+	server     core.OAuthServer
+	jwtService core.JWTService
+	config     *config.Config
+}
+
+func NewOAuthController(server core.OAuthServer, jwtService core.JWTService, config *config.Config) OAuthController {
+	return oauthController{
+		server:     server,
+		jwtService: jwtService,
+		config:     config,
+	}
+}
+
+// Authorize godoc
+//
+//	@Summary		OAuth2 authorization endpoint
+//	@Description	Use this endpoint to get an authorization code for the logged in user, who is sent to the login page first otherwise
+//	@Tags			oauth
+//	@Success		302	{string}	redirect to the client with a code, or an error
+//	@Failure		400	{object}	http.HTTPErrorMessage
+//	@Router			/oauth/authorize [get]
+//	@Param			response_type			query	string	true	"code"
+//	@Param			client_id				query	string	true	"Client ID"
+//	@Param			redirect_uri			query	string	false	"One of the redirect URIs of the client"
+//	@Param			scope					query	string	false	"Space separated scopes, all the scopes of the client by default"
+//	@Param			state					query	string	false	"State of the client"
+//	@Param			code_challenge			query	string	true	"PKCE code challenge"
+//	@Param			code_challenge_method	query	string	true	"S256"
+//	@Param			nonce					query	string	false	"Nonce of the ID token"
+func (o oauthController) Authorize() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request := &core.AuthorizationRequest{
+			ResponseType:        c.Query("response_type"),
+			ClientID:            c.Query("client_id"),
+			RedirectURI:         c.Query("redirect_uri"),
+			Scope:               c.Query("scope"),
+			State:               c.Query("state"),
+			CodeChallenge:       c.Query("code_challenge"),
+			CodeChallengeMethod: c.Query("code_challenge_method"),
+			Nonce:               c.Query("nonce"),
+		}
+		_, err := o.server.ValidateAuthorizationRequest(request)
+		var oauth_error *core.OAuthError
+		if errors.As(err, &oauth_error) {
+			return c.Redirect(o.authorizationError(request, oauth_error))
+		}
+		if err == core.ErrUnknownClient || err == core.ErrInvalidRedirectURI {
+			// never redirect to a URI the client didn't register
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		// only the login session of the browser may grant codes, never a token already handed to a client
+		claims := SessionClaims(c, o.jwtService, &o.config.Cookies)
+		if claims == nil {
+			if o.config.OAuthServer.LoginURL == "" || c.Query("prompt") == "none" {
+				return c.Redirect(o.authorizationError(request, core.ErrLoginRequired.(*core.OAuthError)))
+			}
+			// the user comes back to this very request once logged in
+			login_url, err := url.Parse(o.config.OAuthServer.LoginURL)
+			if err != nil {
+				return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+					Error: err.Error(),
+				})
+			}
+			query := login_url.Query()
+			query.Set("return_to", strings.TrimSuffix(o.config.OAuthServer.Issuer, "/")+c.OriginalURL())
+			login_url.RawQuery = query.Encode()
+			return c.Redirect(login_url.String())
+		}
+
+		redirect_url, err := o.server.Authorize(request, claims)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Redirect(redirect_url)
+	}
+}
+
+// Token godoc
+//
+//	@Summary		OAuth2 token endpoint
+//	@Description	Use this endpoint to exchange an authorization code, a refresh token or the credentials of the client for tokens.
+//	@Description	Confidential clients authenticate with HTTP Basic or with client_id and client_secret in the form, public clients only send their client_id.
+//	@Tags			oauth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			token	formData	http.OAuthTokenValidator	true	"Token request"
+//	@Success		200	{object}	core.TokenResponse
+//	@Failure		400	{object}	http.HTTPOAuthError
+//	@Failure		401	{object}	http.HTTPOAuthError
+//	@Router			/oauth/token [post]
+func (o oauthController) Token() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// tokens must never be cached
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set(fiber.HeaderPragma, "no-cache")
+
+		e := new(OAuthTokenValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPOAuthError{
+				Error:            "invalid_request",
+				ErrorDescription: err.Error(),
+			})
+		}
+		client_id, client_secret, basic := clientCredentials(c)
+		if !basic {
+			client_id, client_secret = e.ClientID, e.ClientSecret
+		}
+
+		response, err := o.server.Token(&core.TokenRequest{
+			GrantType:    e.GrantType,
+			ClientID:     client_id,
+			ClientSecret: client_secret,
+			Code:         e.Code,
+			RedirectURI:  e.RedirectURI,
+			CodeVerifier: e.CodeVerifier,
+			RefreshToken: e.RefreshToken,
+			Scope:        e.Scope,
+		}, core.NewClientInfo(c))
+		var oauth_error *core.OAuthError
+		if errors.As(err, &oauth_error) {
+			status := fiber.StatusBadRequest
+			if err == core.ErrInvalidClient {
+				log.Warnw("Security event: client authentication failed", "client_id", client_id, "ip", c.IP())
+				status = fiber.StatusUnauthorized
+				if basic {
+					c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+				}
+			}
+			return c.Status(status).JSON(HTTPOAuthError{
+				Error:            oauth_error.Code,
+				ErrorDescription: oauth_error.Description,
+			})
+		}
+		if err != nil {
+			log.Error("Couldn't issue tokens: ", err)
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPOAuthError{
+				Error: "server_error",
+			})
+		}
+		return c.JSON(response)
+	}
+}
+
+// UserInfo godoc
+//
+//	@Summary		OpenID Connect userinfo endpoint
+//	@Description	Use this endpoint to get the user an access token was issued for
+//	@Tags			oauth
+//	@Produce		json
+//	@Success		200	{object}	core.UserInfo
+//	@Failure		401	{object}	http.HTTPOAuthError
+//	@Router			/oauth/userinfo [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o oauthController) UserInfo() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !found {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).JSON(HTTPOAuthError{
+				Error: "invalid_token",
+			})
+		}
+		claims, err := o.jwtService.VerifyAccessToken(token)
+		if err != nil || claims.UserID == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(HTTPOAuthError{
+				Error: "invalid_token",
+			})
+		}
+
+		user_info, err := o.server.UserInfo(claims)
+		if err == types.ErrNotFound {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(HTTPOAuthError{
+				Error: "invalid_token",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPOAuthError{
+				Error: "server_error",
+			})
+		}
+		return c.JSON(user_info)
+	}
+}
+
+// Discovery godoc
+//
+//	@Summary		OpenID Connect discovery document
+//	@Description	Use this endpoint to find the endpoints and capabilities of the auth service as an OpenID Connect provider
+//	@Tags			oauth
+//	@Produce		json
+//	@Success		200	{object}	core.DiscoveryDocument
+//	@Router			/.well-known/openid-configuration [get]
+func (o oauthController) Discovery() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(o.server.Discovery())
+	}
+}
+
+// authorizationError returns the redirect URI of the request, along with the error for the client
+func (o oauthController) authorizationError(request *core.AuthorizationRequest, oauth_error *core.OAuthError) string {
+	redirect_url, _ := url.Parse(request.RedirectURI) // checked against the URIs of the client already
+	query := redirect_url.Query()
+	query.Set("error", oauth_error.Code)
+	query.Set("error_description", oauth_error.Description)
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	query.Set("iss", o.config.OAuthServer.Issuer)
+	redirect_url.RawQuery = query.Encode()
+	return redirect_url.String()
+}
+
+// clientCredentials reads the credentials of the client from HTTP Basic authentication, their parts being form encoded
+func clientCredentials(c *fiber.Ctx) (string, string, bool) {
+	encoded, found := strings.CutPrefix(c.Get("Authorization"), "Basic ")
+	if !found {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	client_id, client_secret, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", false
+	}
+	client_id, err = url.QueryUnescape(client_id)
+	if err != nil {
+		return "", "", false
+	}
+	client_secret, err = url.QueryUnescape(client_secret)
+	if err != nil {
+		return "", "", false
+	}
+	return client_id, client_secret, true
+}
+
+func (o oauthController) Register(app *fiber.App) {
+	app.Get("/.well-known/openid-configuration", o.Discovery())
+	app.Get("/oauth/authorize", o.Authorize())
+	app.Post("/oauth/token", o.Token())
+	app.Get("/oauth/userinfo", o.UserInfo())
+	app.Post("/oauth/userinfo", o.UserInfo())
+}
@@ -1,6 +1,8 @@
 package http
 
 import (
+	"time"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
@@ -23,6 +25,10 @@ type AuthLocalVerificationValidator struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
+type AuthRenewValidator struct {
+	BaseValidator
+	RefreshToken string `json:"refresh_token"`
+}
 
 func (a *AuthLocalValidator) Validate(c *fiber.Ctx) error {
 	validate := validator.New(validator.WithRequiredStructEnabled())
@@ -51,3 +57,148 @@ func (a *AuthLocalVerificationValidator) Validate(c *fiber.Ctx) error {
 
 	return nil
 }
+
+func (a *AuthRenewValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(a); err != nil {
+			return err
+		}
+	}
+
+	if err := validate.Struct(a); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type SigningKeyValidator struct {
+	BaseValidator
+	Algorithm   string     `json:"algorithm" validate:"omitempty,oneof=RS256 EdDSA"`
+	ActivatesAt *time.Time `json:"activates_at"` // defaults to now
+}
+
+type RetireSigningKeyValidator struct {
+	BaseValidator
+	RetiresAt *time.Time `json:"retires_at"` // defaults to the end of life of the tokens signed now
+}
+
+func (s *SigningKeyValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(s); err != nil {
+			return err
+		}
+	}
+
+	if err := validate.Struct(s); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *RetireSigningKeyValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(r); err != nil {
+			return err
+		}
+	}
+
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OAuthTokenValidator is the form posted to the token endpoint, the fields needed depending on the grant type
+type OAuthTokenValidator struct {
+	BaseValidator
+	GrantType    string `form:"grant_type" validate:"required"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+}
+
+func (o *OAuthTokenValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(o); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(o); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OAuthClientValidator registers an OAuth client
+type OAuthClientValidator struct {
+	BaseValidator
+	Name         string   `json:"name" validate:"required"`
+	RedirectURIs []string `json:"redirect_uris" validate:"dive,url"`
+	GrantTypes   []string `json:"grant_types" validate:"required,min=1,dive,oneof=authorization_code refresh_token client_credentials"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"` // for apps that can't keep a secret, which get none
+}
+
+func (o *OAuthClientValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(o); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(o); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PersonalAccessTokenValidator mints a personal access token
+type PersonalAccessTokenValidator struct {
+	BaseValidator
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"` // defaults to the default lifetime of the tokens
+}
+
+func (p *PersonalAccessTokenValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(p); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BackchannelLogoutValidator is the form Keycloak posts when a session ends on its side
+type BackchannelLogoutValidator struct {
+	BaseValidator
+	LogoutToken string `form:"logout_token" validate:"required"`
+}
+
+func (b *BackchannelLogoutValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(b); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(b); err != nil {
+		return err
+	}
+
+	return nil
+}
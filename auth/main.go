@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	"github.com/gistsapp/api/auth/config"
 	"github.com/gistsapp/api/auth/core"
 	"github.com/gistsapp/api/auth/http"
 	"github.com/gistsapp/api/auth/repositories"
+	"github.com/gistsapp/api/auth/utils"
 	"github.com/gofiber/fiber/v2/log"
 )
 
@@ -34,14 +36,60 @@ func main() {
 		}
 	}
 	user_service := core.NewUserService(db)
-	jwt_service := core.NewJWTService(conf.JWTSecretKey, db)
-	email_repository := repositories.NewEmailService(conf.EmailService)
-	auth_service := core.NewAuthService(conf.AuthProviders, jwt_service, user_service, db, email_repository)
+	key_store, err := repositories.NewFileKeyStore(conf.JWT.KeysDir)
+	if err != nil {
+		panic(err)
+	}
+	keyring, err := core.LoadKeyring(conf.JWT, key_store)
+	if err != nil {
+		panic(err)
+	}
+	if conf.TokenPepper == "" {
+		panic("token_pepper must be set")
+	}
+	hasher := core.NewTokenHasher(conf.TokenPepper)
+	jwt_service := core.NewJWTService(keyring, db, hasher, conf.JWT)
+	mail_transport, err := repositories.NewMailTransport(conf.EmailService)
+	if err != nil {
+		panic(err)
+	}
+	mail_outbox := core.NewMailOutbox(db, mail_transport, conf.MailOutbox)
+	mail_outbox.Start(context.Background())
+	email_repository, err := repositories.NewEmailService(conf.EmailService, mail_outbox)
+	if err != nil {
+		panic(err)
+	}
+	code_alphabet, err := utils.AlphabetByName(conf.VerificationCode.Alphabet)
+	if err != nil {
+		panic(err)
+	}
+	code_generator, err := utils.NewCodeGenerator(conf.VerificationCode.Length, code_alphabet)
+	if err != nil {
+		panic(err)
+	}
+	var keycloak *core.Keycloak
+	if conf.Keycloak.Enabled {
+		keycloak, err = core.NewKeycloak(context.Background(), conf.Keycloak)
+		if err != nil {
+			panic(err)
+		}
+	}
+	auth_service, err := core.NewAuthService(conf.AuthProviders, keycloak, jwt_service, user_service, db, email_repository, hasher, code_generator, conf.VerificationCode, conf.MagicLink, conf.AccountLinking, conf.ReturnTo)
+	if err != nil {
+		panic(err)
+	}
+
+	pat_service := core.NewPersonalAccessTokenService(db, hasher, conf.PersonalAccessTokens)
 
-	auth_handler := http.NewAuthController(auth_service, &conf, jwt_service)
+	auth_handler := http.NewAuthController(auth_service, &conf, jwt_service, pat_service)
 	docs_handler := http.NewDocsHandler()
+	jwks_handler := http.NewJWKSHandler(jwt_service)
+	oauth_server := core.NewOAuthServer(jwt_service, db, hasher, conf.OAuthServer)
+	oauth_handler := http.NewOAuthController(oauth_server, jwt_service, &conf)
+	internal_handler := http.NewInternalController(user_service, jwt_service, pat_service)
+	admin_handler := http.NewAdminController(jwt_service, mail_outbox, oauth_server, &conf)
 
 	server := http.NewServer(conf.Port)
-	server.Setup(auth_handler, docs_handler)
+	server.Setup(auth_handler, docs_handler, jwks_handler, oauth_handler, internal_handler, admin_handler)
 	server.Ignite()
 }
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS token_family_id_idx;
+
+ALTER TABLE token DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE token DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,11 @@
+-- every refresh token descending from the same login belongs to the same family
+ALTER TABLE token ADD COLUMN family_id uuid;
+
+UPDATE token SET family_id = token_id;
+
+ALTER TABLE token ALTER COLUMN family_id SET NOT NULL;
+
+-- rotated tokens are kept until they expire to detect their reuse
+ALTER TABLE token ADD COLUMN rotated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS token_family_id_idx ON token(family_id);
@@ -0,0 +1,13 @@
+-- access tokens revoked before their expiration, e.g. on logout
+CREATE TABLE IF NOT EXISTS revoked_access_token(
+  jti VARCHAR(255) PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
+
+-- every access token of the user issued before revoked_before is rejected, used to log out everywhere
+CREATE TABLE IF NOT EXISTS user_token_revocation(
+  user_id uuid PRIMARY KEY,
+  revoked_before TIMESTAMP NOT NULL
+);
+
+ALTER TABLE user_token_revocation ADD CONSTRAINT user_token_revocation_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_entity(user_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS token_user_id_idx;
+
+ALTER TABLE token DROP COLUMN IF EXISTS ip;
+ALTER TABLE token DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE token DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE token DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,7 @@
+-- a refresh token family is a session, described by the columns of its last token
+ALTER TABLE token ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE token ADD COLUMN last_used_at TIMESTAMP;
+ALTER TABLE token ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE token ADD COLUMN ip VARCHAR(45) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS token_user_id_idx ON token(user_id);
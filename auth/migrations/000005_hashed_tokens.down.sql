@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS token_token_key;
+
+-- hashed tokens can't be turned back into plaintext ones
+DELETE FROM token;
+DELETE FROM verification_token;
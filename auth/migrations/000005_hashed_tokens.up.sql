@@ -0,0 +1,6 @@
+-- refresh tokens and verification codes are now stored as keyed hashes,
+-- the plaintext ones can't be looked up anymore so they are invalidated
+DELETE FROM token;
+DELETE FROM verification_token;
+
+CREATE UNIQUE INDEX IF NOT EXISTS token_token_key ON token(token);
@@ -0,0 +1,3 @@
+ALTER TABLE verification_token DROP COLUMN IF EXISTS attempts;
+ALTER TABLE verification_token DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE verification_token DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,4 @@
+-- codes that were sent before this migration have no known age, so they expire right away
+ALTER TABLE verification_token ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE verification_token ADD COLUMN expires_at TIMESTAMP NOT NULL DEFAULT NOW();
+ALTER TABLE verification_token ADD COLUMN attempts INT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS verification_token_link_token_key;
+
+ALTER TABLE verification_token DROP COLUMN IF EXISTS link_expires_at;
+ALTER TABLE verification_token DROP COLUMN IF EXISTS link_nonce;
+ALTER TABLE verification_token DROP COLUMN IF EXISTS link_token;
@@ -0,0 +1,6 @@
+-- hashes of the magic link sent along with the code, and of the nonce of the browser it was sent to
+ALTER TABLE verification_token ADD COLUMN link_token VARCHAR(255);
+ALTER TABLE verification_token ADD COLUMN link_nonce VARCHAR(255);
+ALTER TABLE verification_token ADD COLUMN link_expires_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS verification_token_link_token_key ON verification_token(link_token);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS mail_outbox(
+  mail_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  sender VARCHAR(320) NOT NULL,
+  recipient VARCHAR(320) NOT NULL,
+  subject TEXT NOT NULL,
+  html TEXT NOT NULL,
+  text TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  last_error TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS mail_outbox_next_attempt_at_idx ON mail_outbox(next_attempt_at) WHERE status <> 'dead';
@@ -0,0 +1,8 @@
+-- roles granted to the users by the identity provider, synchronized on every login with it
+CREATE TABLE IF NOT EXISTS user_role(
+  user_id uuid NOT NULL,
+  role VARCHAR(255) NOT NULL,
+  PRIMARY KEY (user_id, role)
+);
+
+ALTER TABLE user_role ADD CONSTRAINT user_role_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_entity(user_id) ON DELETE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE token DROP COLUMN IF EXISTS federated_identity_id;
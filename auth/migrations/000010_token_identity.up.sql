@@ -0,0 +1,3 @@
+-- the identity the session was opened with, which access tokens name as their subject
+-- sessions opened before this migration have none, the first identity of their user is used instead
+ALTER TABLE token ADD COLUMN federated_identity_id VARCHAR(255);
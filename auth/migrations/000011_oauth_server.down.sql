@@ -0,0 +1,5 @@
+ALTER TABLE token DROP CONSTRAINT IF EXISTS token_client_id_fkey;
+ALTER TABLE token DROP COLUMN IF EXISTS scope;
+ALTER TABLE token DROP COLUMN IF EXISTS client_id;
+DROP TABLE IF EXISTS oauth_code;
+DROP TABLE IF EXISTS oauth_client;
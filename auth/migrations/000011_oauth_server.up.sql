@@ -0,0 +1,30 @@
+-- our apps getting tokens from the auth service, acting as their OAuth 2.1 / OpenID Connect provider
+CREATE TABLE IF NOT EXISTS oauth_client(
+  client_id VARCHAR(255) PRIMARY KEY,
+  secret VARCHAR(255), -- hash of the secret, public clients have none
+  name VARCHAR(255) NOT NULL,
+  redirect_uris TEXT NOT NULL DEFAULT '', -- space separated, like the grant types and scopes
+  grant_types TEXT NOT NULL DEFAULT '',
+  scopes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS oauth_code(
+  code VARCHAR(255) PRIMARY KEY, -- hash of the code
+  client_id VARCHAR(255) NOT NULL,
+  user_id uuid NOT NULL,
+  federated_identity_id VARCHAR(255) NOT NULL,
+  redirect_uri TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  code_challenge VARCHAR(255) NOT NULL,
+  nonce TEXT,
+  expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE oauth_code ADD CONSTRAINT oauth_code_client_id_fkey FOREIGN KEY (client_id) REFERENCES oauth_client(client_id) ON DELETE CASCADE;
+ALTER TABLE oauth_code ADD CONSTRAINT oauth_code_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_entity(user_id) ON DELETE CASCADE;
+
+-- sessions opened by a client can only be renewed by it, with the scope it was granted
+ALTER TABLE token ADD COLUMN client_id VARCHAR(255);
+ALTER TABLE token ADD COLUMN scope TEXT;
+ALTER TABLE token ADD CONSTRAINT token_client_id_fkey FOREIGN KEY (client_id) REFERENCES oauth_client(client_id) ON DELETE CASCADE;
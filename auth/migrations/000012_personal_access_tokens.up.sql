@@ -0,0 +1,16 @@
+-- tokens users mint for their scripts, only the hash of the token is stored
+CREATE TABLE IF NOT EXISTS personal_access_token(
+  pat_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id uuid NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  token VARCHAR(255) NOT NULL,
+  scope TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP
+);
+
+ALTER TABLE personal_access_token ADD CONSTRAINT personal_access_token_token_key UNIQUE (token);
+ALTER TABLE personal_access_token ADD CONSTRAINT personal_access_token_user_id_fkey FOREIGN KEY (user_id) REFERENCES user_entity(user_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS personal_access_token_user_id_idx ON personal_access_token(user_id);
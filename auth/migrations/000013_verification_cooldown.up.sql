@@ -0,0 +1,7 @@
+-- when the last code was sent to each email, kept apart from the codes so that burning a code doesn't lift the cooldown
+CREATE TABLE IF NOT EXISTS verification_cooldown(
+  email VARCHAR(255) PRIMARY KEY,
+  sent_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO verification_cooldown (email, sent_at) SELECT email, created_at FROM verification_token ON CONFLICT DO NOTHING;
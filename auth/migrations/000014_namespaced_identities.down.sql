@@ -0,0 +1,7 @@
+UPDATE token SET federated_identity_id = substr(federated_identity_id, strpos(federated_identity_id, ':') + 1) WHERE federated_identity_id IS NOT NULL;
+UPDATE oauth_code SET federated_identity_id = substr(federated_identity_id, strpos(federated_identity_id, ':') + 1);
+UPDATE federated_identity SET federated_identity_id = substr(federated_identity_id, strpos(federated_identity_id, ':') + 1);
+
+ALTER TABLE oauth_code ALTER COLUMN federated_identity_id TYPE VARCHAR(255);
+ALTER TABLE token ALTER COLUMN federated_identity_id TYPE VARCHAR(255);
+ALTER TABLE federated_identity ALTER COLUMN federated_identity_id TYPE VARCHAR(255);
@@ -0,0 +1,14 @@
+-- the ids of the identities are namespaced by their provider, which can't assert the ids of the users of the other providers
+ALTER TABLE federated_identity ALTER COLUMN federated_identity_id TYPE VARCHAR(512);
+ALTER TABLE token ALTER COLUMN federated_identity_id TYPE VARCHAR(512);
+ALTER TABLE oauth_code ALTER COLUMN federated_identity_id TYPE VARCHAR(512);
+
+UPDATE token SET federated_identity_id = federated_identity.provider || ':' || federated_identity.federated_identity_id
+FROM federated_identity
+WHERE token.federated_identity_id = federated_identity.federated_identity_id AND token.user_id = federated_identity.user_id;
+
+UPDATE oauth_code SET federated_identity_id = federated_identity.provider || ':' || federated_identity.federated_identity_id
+FROM federated_identity
+WHERE oauth_code.federated_identity_id = federated_identity.federated_identity_id AND oauth_code.user_id = federated_identity.user_id;
+
+UPDATE federated_identity SET federated_identity_id = provider || ':' || federated_identity_id;
@@ -1,9 +1,11 @@
 package repositories
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gistsapp/api/types"
 	"github.com/golang-migrate/migrate/v4"
@@ -27,14 +29,48 @@ type Database interface {
 	GetFederatedIdentityByID(id string) (*types.FederatedIdentity, error)
 	GetFederatedIdentityByUserID(id string) (*types.FederatedIdentity, error)
 	DeleteFederatedIdentity(id string) error
+	GetFederatedIdentitiesByUserID(user_id string) ([]*types.FederatedIdentity, error)
+	DeleteUserFederatedIdentity(user_id string, id string) error
+	GetUsersByEmail(email string) ([]*types.User, error)
 	CreateOpaqueToken(opaque_token *types.OpaqueToken) (*types.OpaqueToken, error)
 	GetOpaqueTokenByID(id string) (*types.OpaqueToken, error)
 	GetOpaqueTokenByUserEmail(email string) (*types.OpaqueToken, error)
 	GetOpaqueTokenByToken(token string) (*types.OpaqueToken, error)
 	DeleteOpaqueToken(id string) error
+	RotateOpaqueToken(id string) error
+	DeleteOpaqueTokenFamily(family_id string) error
+	DeleteOpaqueTokensByUserID(user_id string) error
+	RevokeAccessToken(jti string, expires_at time.Time) error
+	RevokeUserAccessTokens(user_id string, revoked_before time.Time) error
+	IsAccessTokenRevoked(jti string, user_id string, session_id string, issued_at time.Time) (bool, error)
+	GetActiveOpaqueTokensByUserID(user_id string) ([]*types.OpaqueToken, error)
+	DeleteUserOpaqueTokenFamily(user_id string, family_id string) error
+	DeleteOpaqueTokensByFederatedIdentity(user_id string, federated_identity_id string) error
 	CreateVerificationToken(verification_token *types.VerificationToken) (*types.VerificationToken, error)
 	GetVerificationTokenByEmail(email string) (*types.VerificationToken, error)
+	GetVerificationTokenByLink(link_token string) (*types.VerificationToken, error)
 	DeleteVerificationToken(email string, value string) error
+	IncrementVerificationTokenAttempts(email string, value string, max_attempts int) (int, error)
+	StartVerificationCooldown(email string, sent_at time.Time, cooldown time.Duration) error
+	GetUserRoles(user_id string) ([]string, error)
+	SetUserRoles(user_id string, roles []string) error
+	CreateOutboxMail(mail *types.OutboxMail, ttl time.Duration) (*types.OutboxMail, error)
+	ClaimOutboxMails(limit int, lease time.Duration) ([]*types.OutboxMail, error)
+	DeleteOutboxMail(id string) error
+	RescheduleOutboxMail(id string, delay time.Duration, last_error string) error
+	KillOutboxMail(id string, last_error string) error
+	RetryOutboxMail(id string) error
+	GetOutboxStatus() (*types.OutboxStatus, error)
+	CreateOAuthClient(client *types.OAuthClient) (*types.OAuthClient, error)
+	GetOAuthClientByID(id string) (*types.OAuthClient, error)
+	GetOAuthClients() ([]*types.OAuthClient, error)
+	DeleteOAuthClient(id string) error
+	CreateAuthorizationCode(code *types.AuthorizationCode) (*types.AuthorizationCode, error)
+	ConsumeAuthorizationCode(code string) (*types.AuthorizationCode, error)
+	CreatePersonalAccessToken(token *types.PersonalAccessToken) (*types.PersonalAccessToken, error)
+	GetPersonalAccessTokensByUserID(user_id string) ([]*types.PersonalAccessToken, error)
+	UsePersonalAccessToken(token string) (*types.PersonalAccessToken, error)
+	DeleteUserPersonalAccessToken(user_id string, id string) error
 }
 
 type PgDatabase struct {
@@ -141,6 +177,42 @@ func (db *PgDatabase) DeleteFederatedIdentity(id string) error {
 	return nil
 }
 
+func (db *PgDatabase) GetFederatedIdentitiesByUserID(user_id string) ([]*types.FederatedIdentity, error) {
+	federated_identities := []*types.FederatedIdentity{}
+	err := db.db.Select(&federated_identities, "SELECT * FROM federated_identity WHERE user_id = $1 ORDER BY provider", user_id)
+	if err != nil {
+		return nil, err
+	}
+	return federated_identities, nil
+}
+
+// DeleteUserFederatedIdentity unlinks an identity from the user, as long as it isn't their last one.
+// It returns sql.ErrNoRows if nothing was deleted.
+func (db *PgDatabase) DeleteUserFederatedIdentity(user_id string, id string) error {
+	result, err := db.db.Exec(`DELETE FROM federated_identity WHERE user_id = $1 AND federated_identity_id = $2
+		AND EXISTS(SELECT 1 FROM federated_identity WHERE user_id = $1 AND federated_identity_id <> $2)`, user_id, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *PgDatabase) GetUsersByEmail(email string) ([]*types.User, error) {
+	users := []*types.User{}
+	err := db.db.Select(&users, "SELECT * FROM user_entity WHERE LOWER(email) = LOWER($1)", email)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (db *PgDatabase) GetFederatedIdentityByUserID(id string) (*types.FederatedIdentity, error) {
 	var federated_identity types.FederatedIdentity
 	err := db.db.Get(&federated_identity, "SELECT * FROM federated_identity WHERE user_id = $1", id)
@@ -152,7 +224,7 @@ func (db *PgDatabase) GetFederatedIdentityByUserID(id string) (*types.FederatedI
 
 func (db *PgDatabase) CreateOpaqueToken(opaque_token *types.OpaqueToken) (*types.OpaqueToken, error) {
 	var created_opaque_token types.OpaqueToken
-	err := db.db.Get(&created_opaque_token, "INSERT INTO token (token_id, user_id, token, expires_at) VALUES ($1, $2, $3, $4) RETURNING *", opaque_token.ID, opaque_token.UserID, opaque_token.Token, opaque_token.ExpiresAt)
+	err := db.db.Get(&created_opaque_token, "INSERT INTO token (token_id, user_id, token, expires_at, family_id, created_at, last_used_at, user_agent, ip, federated_identity_id, client_id, scope) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING *", opaque_token.ID, opaque_token.UserID, opaque_token.Token, opaque_token.ExpiresAt, opaque_token.FamilyID, opaque_token.CreatedAt, opaque_token.LastUsedAt, opaque_token.UserAgent, opaque_token.IP, opaque_token.FederatedIdentityID, opaque_token.ClientID, opaque_token.Scope)
 	if err != nil {
 		return nil, err
 	}
@@ -168,14 +240,113 @@ func (db *PgDatabase) GetOpaqueTokenByID(id string) (*types.OpaqueToken, error)
 	return &opaque_token, nil
 }
 
+// DeleteOpaqueToken returns sql.ErrNoRows if the token was already deleted
 func (db *PgDatabase) DeleteOpaqueToken(id string) error {
-	_, err := db.db.Exec("DELETE FROM token WHERE token_id = $1", id)
+	result, err := db.db.Exec("DELETE FROM token WHERE token_id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RotateOpaqueToken marks the token as exchanged, and returns sql.ErrNoRows if it already was
+func (db *PgDatabase) RotateOpaqueToken(id string) error {
+	result, err := db.db.Exec("UPDATE token SET rotated_at = NOW() WHERE token_id = $1 AND rotated_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	rotated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rotated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *PgDatabase) DeleteOpaqueTokenFamily(family_id string) error {
+	_, err := db.db.Exec("DELETE FROM token WHERE family_id = $1", family_id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *PgDatabase) DeleteOpaqueTokensByUserID(user_id string) error {
+	_, err := db.db.Exec("DELETE FROM token WHERE user_id = $1", user_id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevokeAccessToken adds the jti to the denylist, expired entries being purged along the way
+func (db *PgDatabase) RevokeAccessToken(jti string, expires_at time.Time) error {
+	_, err := db.db.Exec("DELETE FROM revoked_access_token WHERE expires_at < NOW()")
 	if err != nil {
 		return err
 	}
+	_, err = db.db.Exec("INSERT INTO revoked_access_token (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expires_at)
+	return err
+}
+
+// RevokeUserAccessTokens rejects the access tokens of the user issued before revoked_before.
+// It is truncated to the second like the iat claim, so that the tokens issued within the same second, such as the first token of a new session, stay valid.
+func (db *PgDatabase) RevokeUserAccessTokens(user_id string, revoked_before time.Time) error {
+	_, err := db.db.Exec("INSERT INTO user_token_revocation (user_id, revoked_before) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before", user_id, revoked_before.Truncate(time.Second))
+	return err
+}
+
+// IsAccessTokenRevoked tells if the token was revoked on its own, along with every token of its user,
+// or if the session it was issued for doesn't exist anymore. Tokens issued to OAuth clients themselves have no user_id.
+func (db *PgDatabase) IsAccessTokenRevoked(jti string, user_id string, session_id string, issued_at time.Time) (bool, error) {
+	var revoked bool
+	err := db.db.Get(&revoked, `SELECT EXISTS(SELECT 1 FROM revoked_access_token WHERE jti = $1)
+		OR EXISTS(SELECT 1 FROM user_token_revocation WHERE user_id = NULLIF($2, '')::uuid AND revoked_before > $3)
+		OR ($4 <> '' AND NOT EXISTS(SELECT 1 FROM token WHERE family_id::text = $4))`, jti, user_id, issued_at, session_id)
+	return revoked, err
+}
+
+// GetActiveOpaqueTokensByUserID returns the last token of every family of the user that hasn't expired
+func (db *PgDatabase) GetActiveOpaqueTokensByUserID(user_id string) ([]*types.OpaqueToken, error) {
+	opaque_tokens := []*types.OpaqueToken{}
+	err := db.db.Select(&opaque_tokens, "SELECT * FROM token WHERE user_id = $1 AND rotated_at IS NULL AND expires_at > NOW() ORDER BY last_used_at DESC", user_id)
+	if err != nil {
+		return nil, err
+	}
+	return opaque_tokens, nil
+}
+
+// DeleteUserOpaqueTokenFamily returns sql.ErrNoRows if the user has no such family
+func (db *PgDatabase) DeleteUserOpaqueTokenFamily(user_id string, family_id string) error {
+	result, err := db.db.Exec("DELETE FROM token WHERE user_id = $1 AND family_id::text = $2", user_id, family_id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
+// DeleteOpaqueTokensByFederatedIdentity ends the sessions the user opened with the identity
+func (db *PgDatabase) DeleteOpaqueTokensByFederatedIdentity(user_id string, federated_identity_id string) error {
+	_, err := db.db.Exec("DELETE FROM token WHERE user_id = $1 AND federated_identity_id = $2", user_id, federated_identity_id)
+	return err
+}
+
 func (db *PgDatabase) GetOpaqueTokenByUserEmail(email string) (*types.OpaqueToken, error) {
 	var opaque_token types.OpaqueToken
 	err := db.db.Get(&opaque_token, "SELECT * FROM token WHERE user_id = (SELECT user_id FROM user_entity WHERE email = $1)", email)
@@ -205,7 +376,7 @@ func (db *PgDatabase) GetUserThroughFederatedIdentity(federated_id string) (*typ
 
 func (db *PgDatabase) CreateVerificationToken(verification_token *types.VerificationToken) (*types.VerificationToken, error) {
 	var created_verification_token types.VerificationToken
-	err := db.db.Get(&created_verification_token, "INSERT INTO verification_token (token, email) VALUES ($1, $2) RETURNING *", verification_token.Token, verification_token.Email)
+	err := db.db.Get(&created_verification_token, "INSERT INTO verification_token (token, email, created_at, expires_at, link_token, link_nonce, link_expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *", verification_token.Token, verification_token.Email, verification_token.CreatedAt, verification_token.ExpiresAt, verification_token.LinkToken, verification_token.LinkNonce, verification_token.LinkExpiresAt)
 	if err != nil {
 		return nil, err
 	}
@@ -222,11 +393,267 @@ func (db *PgDatabase) GetVerificationTokenByEmail(email string) (*types.Verifica
 }
 
 
+func (db *PgDatabase) GetVerificationTokenByLink(link_token string) (*types.VerificationToken, error) {
+	var verification_token types.VerificationToken
+	err := db.db.Get(&verification_token, "SELECT * FROM verification_token WHERE link_token = $1", link_token)
+	if err != nil {
+		return nil, err
+	}
+	return &verification_token, nil
+}
+
+// DeleteVerificationToken returns sql.ErrNoRows if there is no such token for the email
 func (db *PgDatabase) DeleteVerificationToken(email string, value string) error {
-	_, err := db.db.Exec("DELETE FROM verification_token WHERE email = $1 AND token = $2", email, value)
+	result, err := db.db.Exec("DELETE FROM verification_token WHERE email = $1 AND token = $2", email, value)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
+// IncrementVerificationTokenAttempts counts an attempt before the code is compared, and returns how many were made.
+// It returns sql.ErrNoRows once max_attempts were made, or if there is no such token for the email anymore:
+// the row is only updated while attempts are left, so concurrent attempts can't get past the limit.
+func (db *PgDatabase) IncrementVerificationTokenAttempts(email string, value string, max_attempts int) (int, error) {
+	var attempts int
+	err := db.db.Get(&attempts, "UPDATE verification_token SET attempts = attempts + 1 WHERE email = $1 AND token = $2 AND attempts < $3 RETURNING attempts", email, value, max_attempts)
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// StartVerificationCooldown records that a code is sent to the email at sent_at, unless one was sent less than cooldown before,
+// in which case it returns sql.ErrNoRows
+func (db *PgDatabase) StartVerificationCooldown(email string, sent_at time.Time, cooldown time.Duration) error {
+	var started string
+	return db.db.Get(&started, "INSERT INTO verification_cooldown (email, sent_at) VALUES ($1, $2) ON CONFLICT (email) DO UPDATE SET sent_at = EXCLUDED.sent_at WHERE verification_cooldown.sent_at <= $3 RETURNING email", email, sent_at, sent_at.Add(-cooldown))
+}
+
+func (db *PgDatabase) GetUserRoles(user_id string) ([]string, error) {
+	roles := []string{}
+	err := db.db.Select(&roles, "SELECT role FROM user_role WHERE user_id = $1 ORDER BY role", user_id)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of the user
+func (db *PgDatabase) SetUserRoles(user_id string, roles []string) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_role WHERE user_id = $1", user_id); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if _, err := tx.Exec("INSERT INTO user_role (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING", user_id, role); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateOutboxMail queues a mail, that expires after ttl unless ttl is 0
+func (db *PgDatabase) CreateOutboxMail(mail *types.OutboxMail, ttl time.Duration) (*types.OutboxMail, error) {
+	var expires_in *float64
+	if ttl > 0 {
+		seconds := ttl.Seconds()
+		expires_in = &seconds
+	}
+	var created_mail types.OutboxMail
+	err := db.db.Get(&created_mail, "INSERT INTO mail_outbox (sender, recipient, subject, html, text, expires_at) VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(secs => $6)) RETURNING *", mail.Sender, mail.Recipient, mail.Subject, mail.HTML, mail.Text, expires_in)
+	if err != nil {
+		return nil, err
+	}
+	return &created_mail, nil
+}
+
+// ClaimOutboxMails leases the mails that are due to a worker, counting an attempt for each of them.
+// Mails locked by another worker are skipped, and the ones whose worker died are claimed again once their lease is over.
+func (db *PgDatabase) ClaimOutboxMails(limit int, lease time.Duration) ([]*types.OutboxMail, error) {
+	mails := []*types.OutboxMail{}
+	err := db.db.Select(&mails, `UPDATE mail_outbox SET status = 'sending', attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE mail_id IN (
+			SELECT mail_id FROM mail_outbox WHERE status <> 'dead' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		) RETURNING *`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return mails, nil
+}
+
+func (db *PgDatabase) DeleteOutboxMail(id string) error {
+	_, err := db.db.Exec("DELETE FROM mail_outbox WHERE mail_id = $1", id)
+	return err
+}
+
+func (db *PgDatabase) RescheduleOutboxMail(id string, delay time.Duration, last_error string) error {
+	_, err := db.db.Exec("UPDATE mail_outbox SET status = 'pending', next_attempt_at = NOW() + make_interval(secs => $2), last_error = $3 WHERE mail_id = $1", id, delay.Seconds(), last_error)
+	return err
+}
+
+// KillOutboxMail moves the mail to the dead letters, where it stays until it is retried
+func (db *PgDatabase) KillOutboxMail(id string, last_error string) error {
+	_, err := db.db.Exec("UPDATE mail_outbox SET status = 'dead', last_error = $2 WHERE mail_id = $1", id, last_error)
+	return err
+}
+
+// RetryOutboxMail queues a dead letter again, and returns sql.ErrNoRows if there is no such dead letter
+func (db *PgDatabase) RetryOutboxMail(id string) error {
+	result, err := db.db.Exec("UPDATE mail_outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW() WHERE mail_id::text = $1 AND status = 'dead'", id)
+	if err != nil {
+		return err
+	}
+	retried, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if retried == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *PgDatabase) GetOutboxStatus() (*types.OutboxStatus, error) {
+	var status types.OutboxStatus
+	err := db.db.Get(&status, `SELECT
+		COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+		COUNT(*) FILTER (WHERE status = 'sending') AS sending,
+		COUNT(*) FILTER (WHERE status = 'dead') AS dead,
+		MIN(created_at) FILTER (WHERE status <> 'dead') AS oldest_mail
+		FROM mail_outbox`)
+	if err != nil {
+		return nil, err
+	}
+	status.DeadLetters = []*types.OutboxMail{}
+	err = db.db.Select(&status.DeadLetters, "SELECT * FROM mail_outbox WHERE status = 'dead' ORDER BY created_at DESC LIMIT 100")
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (db *PgDatabase) CreateOAuthClient(client *types.OAuthClient) (*types.OAuthClient, error) {
+	var created_client types.OAuthClient
+	err := db.db.Get(&created_client, "INSERT INTO oauth_client (client_id, secret, name, redirect_uris, grant_types, scopes) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *", client.ID, client.Secret, client.Name, client.RedirectURIs, client.GrantTypes, client.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &created_client, nil
+}
+
+func (db *PgDatabase) GetOAuthClientByID(id string) (*types.OAuthClient, error) {
+	var client types.OAuthClient
+	err := db.db.Get(&client, "SELECT * FROM oauth_client WHERE client_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (db *PgDatabase) GetOAuthClients() ([]*types.OAuthClient, error) {
+	clients := []*types.OAuthClient{}
+	err := db.db.Select(&clients, "SELECT * FROM oauth_client ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// DeleteOAuthClient returns sql.ErrNoRows if there is no such client, its codes and sessions being deleted along with it
+func (db *PgDatabase) DeleteOAuthClient(id string) error {
+	result, err := db.db.Exec("DELETE FROM oauth_client WHERE client_id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateAuthorizationCode stores a new code, the expired ones that were never exchanged being cleaned up on the way
+func (db *PgDatabase) CreateAuthorizationCode(code *types.AuthorizationCode) (*types.AuthorizationCode, error) {
+	if _, err := db.db.Exec("DELETE FROM oauth_code WHERE expires_at < NOW()"); err != nil {
+		return nil, err
+	}
+	var created_code types.AuthorizationCode
+	err := db.db.Get(&created_code, "INSERT INTO oauth_code (code, client_id, user_id, federated_identity_id, redirect_uri, scope, code_challenge, nonce, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING *", code.Code, code.ClientID, code.UserID, code.FederatedIdentityID, code.RedirectURI, code.Scope, code.CodeChallenge, code.Nonce, code.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &created_code, nil
+}
+
+// ConsumeAuthorizationCode deletes the code and returns it, so that only one request can exchange it.
+// It returns sql.ErrNoRows if the code doesn't exist or was already consumed.
+func (db *PgDatabase) ConsumeAuthorizationCode(code string) (*types.AuthorizationCode, error) {
+	var consumed_code types.AuthorizationCode
+	err := db.db.Get(&consumed_code, "DELETE FROM oauth_code WHERE code = $1 RETURNING *", code)
+	if err != nil {
+		return nil, err
+	}
+	return &consumed_code, nil
+}
+
+func (db *PgDatabase) CreatePersonalAccessToken(token *types.PersonalAccessToken) (*types.PersonalAccessToken, error) {
+	var created_token types.PersonalAccessToken
+	err := db.db.Get(&created_token, "INSERT INTO personal_access_token (user_id, name, token, scope, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", token.UserID, token.Name, token.Token, token.Scope, token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &created_token, nil
+}
+
+func (db *PgDatabase) GetPersonalAccessTokensByUserID(user_id string) ([]*types.PersonalAccessToken, error) {
+	tokens := []*types.PersonalAccessToken{}
+	err := db.db.Select(&tokens, "SELECT * FROM personal_access_token WHERE user_id = $1 ORDER BY created_at DESC", user_id)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// UsePersonalAccessToken returns the token with this hash if it hasn't expired, recording that it was used.
+// It returns sql.ErrNoRows otherwise.
+func (db *PgDatabase) UsePersonalAccessToken(token string) (*types.PersonalAccessToken, error) {
+	var used_token types.PersonalAccessToken
+	err := db.db.Get(&used_token, "UPDATE personal_access_token SET last_used_at = NOW() WHERE token = $1 AND expires_at > NOW() RETURNING *", token)
+	if err != nil {
+		return nil, err
+	}
+	return &used_token, nil
+}
+
+// DeleteUserPersonalAccessToken returns sql.ErrNoRows if the user has no such token
+func (db *PgDatabase) DeleteUserPersonalAccessToken(user_id string, id string) error {
+	result, err := db.db.Exec("DELETE FROM personal_access_token WHERE user_id = $1 AND pat_id::text = $2", user_id, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StoredKey is a signing key as persisted in the keyring, the private key being PEM encoded
+type StoredKey struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at"`
+	RetiresAt   *time.Time `json:"retires_at,omitempty"`
+	PrivateKey  []byte     `json:"-"`
+}
+
+// Abstraction for the storage of the signing keyring
+type KeyStore interface {
+	LoadKeys() ([]*StoredKey, error)
+	SaveKey(key *StoredKey) error
+	DeleteKey(id string) error
+}
+
+// FileKeyStore keeps every private key in its own <kid>.pem file,
+// and their metadata in a keyring.json manifest next to them
+type FileKeyStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+const keyringManifest = "keyring.json"
+
+func NewFileKeyStore(dir string) (*FileKeyStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *FileKeyStore) LoadKeys() ([]*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		key.PrivateKey, err = os.ReadFile(s.keyPath(key.ID))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (s *FileKeyStore) SaveKey(key *StoredKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readManifest()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, stored := range keys {
+		if stored.ID == key.ID {
+			keys[i] = key
+			found = true
+		}
+	}
+	if !found {
+		if err := os.WriteFile(s.keyPath(key.ID), key.PrivateKey, 0600); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	return s.writeManifest(keys)
+}
+
+func (s *FileKeyStore) DeleteKey(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.readManifest()
+	if err != nil {
+		return err
+	}
+
+	remaining := []*StoredKey{}
+	for _, key := range keys {
+		if key.ID != id {
+			remaining = append(remaining, key)
+		}
+	}
+	if err := s.writeManifest(remaining); err != nil {
+		return err
+	}
+	return os.Remove(s.keyPath(id))
+}
+
+func (s *FileKeyStore) keyPath(id string) string {
+	return filepath.Join(s.dir, id+".pem")
+}
+
+func (s *FileKeyStore) readManifest() ([]*StoredKey, error) {
+	keys := []*StoredKey{}
+	data, err := os.ReadFile(filepath.Join(s.dir, keyringManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// the manifest is replaced atomically so that a concurrent reader never sees a partial file
+func (s *FileKeyStore) writeManifest(keys []*StoredKey) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, keyringManifest+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, keyringManifest))
+}
@@ -1,50 +1,144 @@
 package repositories
 
 import (
-	"crypto/tls"
+	"bytes"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/gistsapp/api/auth/config"
 	"github.com/gofiber/fiber/v2/log"
-	gomail "gopkg.in/mail.v2"
+	"golang.org/x/text/language"
 )
 
 type EmailService interface {
-	SendVerificationEmail(email string, value string) error
+	SendVerificationEmail(email string, data VerificationEmail) error
 }
 
-type emailService struct {
-	config config.EmailServiceConfig
+// VerificationEmail is what the verification email templates are rendered with
+type VerificationEmail struct {
+	Code      string
+	MagicLink string // empty when no magic link was requested
+	Language  string    // Accept-Language header of the request, used to pick the locale
+	ExpiresAt time.Time // the email isn't worth sending after that
 }
 
-func NewEmailService(config config.EmailServiceConfig) EmailService {
-	return emailService{
-		config: config,
-	}
+// Mail is a rendered email, ready to be handed to a transport
+type Mail struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	HTML      string    `json:"html"`
+	Text      string    `json:"text"`
+	ExpiresAt time.Time `json:"expires_at"` // zero when the email never expires
+}
+
+// emailTemplates are the templates of one locale.
+// Every email has an HTML and a plaintext version, its subject being defined as <email>.subject in the plaintext one.
+type emailTemplates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
 }
 
-func (e emailService) SendVerificationEmail(email string, token string) error {
-	m := gomail.NewMessage()
-	m.SetHeader("From", e.config.User)
-	m.SetHeader("To", email)
+type emailService struct {
+	config    config.EmailServiceConfig
+	transport MailTransport
+	locales   []string
+	templates map[string]*emailTemplates
+	matcher   language.Matcher
+}
 
-	m.SetBody("text/html", `<html>
-	<head></head>
-	<body>
-		<p>Hello,</p>
-		<p>Please enter the following code to verify your email address:</p>
-		<p>`+token+`</p>
-		<p>If you didn't request this email, please ignore it.</p>
-	</body>
-</html>`)
+// NewEmailService loads the templates of every locale found in the templates directory,
+// i.e. templates_dir/<locale>/<email>.html and templates_dir/<locale>/<email>.txt
+func NewEmailService(config config.EmailServiceConfig, transport MailTransport) (EmailService, error) {
+	if config.From == "" {
+		config.From = config.User
+	}
+	entries, err := os.ReadDir(config.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
 
-	d := gomail.NewDialer(e.config.Host, e.config.Port, e.config.User, e.config.Password)
+	service := emailService{
+		config:    config,
+		transport: transport,
+		templates: map[string]*emailTemplates{},
+	}
+	// the default locale goes first, as the matcher falls back to the first supported tag
+	tags := []language.Tag{language.Make(config.DefaultLocale)}
+	service.locales = []string{config.DefaultLocale}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+		dir := filepath.Join(config.TemplatesDir, locale)
+		html, err := htmltemplate.ParseGlob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		text, err := texttemplate.ParseGlob(filepath.Join(dir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+		service.templates[locale] = &emailTemplates{html: html, text: text}
+		if locale != config.DefaultLocale {
+			tags = append(tags, language.Make(locale))
+			service.locales = append(service.locales, locale)
+		}
+	}
+	if _, ok := service.templates[config.DefaultLocale]; !ok {
+		return nil, ErrMissingDefaultLocale
+	}
+	service.matcher = language.NewMatcher(tags)
+	return service, nil
+}
 
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+func (e emailService) SendVerificationEmail(email string, data VerificationEmail) error {
+	mail, err := e.render("verification", e.locale(data.Language), data)
+	if err != nil {
+		return err
+	}
+	mail.To = email
+	mail.ExpiresAt = data.ExpiresAt
 
-	if err := d.DialAndSend(m); err != nil {
+	if err := e.transport.Send(mail); err != nil {
 		log.Error(err)
 		return err
 	}
-	log.Info("Email sent to " + email)
 	return nil
 }
+
+// locale picks the locale that best matches an Accept-Language header
+func (e emailService) locale(accept_language string) string {
+	tags, _, err := language.ParseAcceptLanguage(accept_language)
+	if err != nil || len(tags) == 0 {
+		return e.config.DefaultLocale
+	}
+	_, index, _ := e.matcher.Match(tags...)
+	return e.locales[index]
+}
+
+func (e emailService) render(name string, locale string, data any) (*Mail, error) {
+	templates := e.templates[locale]
+
+	var subject, text, html bytes.Buffer
+	if err := templates.text.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return nil, err
+	}
+	if err := templates.text.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, err
+	}
+	if err := templates.html.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, err
+	}
+
+	return &Mail{
+		From:    e.config.From,
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gistsapp/api/auth/config"
+	gomail "gopkg.in/mail.v2"
+)
+
+// MailTransport delivers rendered emails
+type MailTransport interface {
+	Send(mail *Mail) error
+}
+
+// NewMailTransport returns the transport selected in the config: smtp, file or memory
+func NewMailTransport(config config.EmailServiceConfig) (MailTransport, error) {
+	switch config.Transport {
+	case "smtp", "":
+		return NewSMTPTransport(config)
+	case "file":
+		return NewFileTransport(config.Directory)
+	case "memory":
+		return NewMemoryTransport(), nil
+	}
+	return nil, ErrUnknownTransport
+}
+
+type SMTPTransport struct {
+	dialer *gomail.Dialer
+}
+
+// NewSMTPTransport connects with implicit TLS, or upgrades the connection with STARTTLS,
+// the server certificate being verified in both cases. Plaintext is only allowed explicitly, for local servers.
+func NewSMTPTransport(config config.EmailServiceConfig) (*SMTPTransport, error) {
+	dialer := gomail.NewDialer(config.Host, config.Port, config.User, config.Password)
+	dialer.TLSConfig = &tls.Config{
+		ServerName: config.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch config.TLS {
+	case "starttls", "":
+		dialer.SSL = false
+		dialer.StartTLSPolicy = gomail.MandatoryStartTLS
+	case "tls":
+		dialer.SSL = true
+	case "none":
+		dialer.SSL = false
+		dialer.StartTLSPolicy = gomail.NoStartTLS
+	default:
+		return nil, ErrUnknownTLSMode
+	}
+
+	return &SMTPTransport{
+		dialer: dialer,
+	}, nil
+}
+
+func (t *SMTPTransport) Send(mail *Mail) error {
+	return t.dialer.DialAndSend(mail.message())
+}
+
+// FileTransport writes every email as an .eml file in a directory, to read them during development
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if dir == "" {
+		return nil, ErrMissingMailDirectory
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileTransport{
+		dir: dir,
+	}, nil
+}
+
+func (t *FileTransport) Send(mail *Mail) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), strings.ReplaceAll(mail.To, "/", "_"))
+	file, err := os.OpenFile(filepath.Join(t.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = mail.message().WriteTo(file)
+	return err
+}
+
+// MemoryTransport keeps the emails it is given, so that tests can inspect them
+type MemoryTransport struct {
+	mu    sync.Mutex
+	mails []*Mail
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		mails: []*Mail{},
+	}
+}
+
+func (t *MemoryTransport) Send(mail *Mail) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mails = append(t.mails, mail)
+	return nil
+}
+
+// Mails returns the emails sent so far
+func (t *MemoryTransport) Mails() []*Mail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Mail{}, t.mails...)
+}
+
+func (m *Mail) message() *gomail.Message {
+	message := gomail.NewMessage()
+	message.SetHeader("From", m.From)
+	message.SetHeader("To", m.To)
+	message.SetHeader("Subject", m.Subject)
+	message.SetBody("text/plain", m.Text)
+	message.AddAlternative("text/html", m.HTML)
+	return message
+}
+
+var ErrUnknownTransport error = errors.New("Unknown mail transport")
+var ErrUnknownTLSMode error = errors.New("Unknown mail TLS mode")
+var ErrMissingMailDirectory error = errors.New("The file mail transport needs a directory")
+var ErrMissingDefaultLocale error = errors.New("No email templates for the default locale")
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	DigitsAlphabet = "0123456789"
+	// Crockford's base32 leaves out I, L, O and U so that codes can't be misread
+	CrockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// CodeGenerator generates one-time codes, such as email login codes or recovery codes.
+// Symbols are drawn from crypto/rand and rejection sampled, so that every one of them is equally likely.
+type CodeGenerator struct {
+	length   int
+	alphabet string
+}
+
+func NewCodeGenerator(length int, alphabet string) (*CodeGenerator, error) {
+	if length <= 0 {
+		return nil, ErrInvalidCodeLength
+	}
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return nil, ErrInvalidAlphabet
+	}
+	for i := range len(alphabet) {
+		if strings.IndexByte(alphabet, alphabet[i]) != i {
+			return nil, ErrInvalidAlphabet
+		}
+	}
+	return &CodeGenerator{
+		length:   length,
+		alphabet: alphabet,
+	}, nil
+}
+
+// AlphabetByName resolves the alphabets that can be set in the config
+func AlphabetByName(name string) (string, error) {
+	switch name {
+	case "digits", "":
+		return DigitsAlphabet, nil
+	case "crockford":
+		return CrockfordAlphabet, nil
+	}
+	return "", ErrInvalidAlphabet
+}
+
+func (g *CodeGenerator) Generate() (string, error) {
+	size := len(g.alphabet)
+	// bytes above the largest multiple of the alphabet size would favor its first symbols
+	limit := 256 - 256%size
+
+	code := make([]byte, 0, g.length)
+	buffer := make([]byte, g.length*2)
+	for len(code) < g.length {
+		if _, err := rand.Read(buffer); err != nil {
+			return "", err
+		}
+		for _, b := range buffer {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, g.alphabet[int(b)%size])
+			if len(code) == g.length {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// RandomToken returns a URL safe token made of size random bytes
+func RandomToken(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// Normalize cleans up a code typed by a user before it is compared to the generated one
+func (g *CodeGenerator) Normalize(code string) string {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+	if g.alphabet == CrockfordAlphabet {
+		code = strings.NewReplacer("I", "1", "L", "1", "O", "0").Replace(strings.ToUpper(code))
+	}
+	return code
+}
+
+var ErrInvalidCodeLength error = errors.New("Code length must be positive")
+var ErrInvalidAlphabet error = errors.New("Invalid code alphabet")
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// every byte once, the largest alphabet there is
+func allBytes() string {
+	alphabet := make([]byte, 256)
+	for i := range alphabet {
+		alphabet[i] = byte(i)
+	}
+	return string(alphabet)
+}
+
+func TestNewCodeGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		length   int
+		alphabet string
+		err      error
+	}{
+		{name: "digits", length: 6, alphabet: DigitsAlphabet},
+		{name: "crockford", length: 8, alphabet: CrockfordAlphabet},
+		{name: "every byte", length: 8, alphabet: allBytes()},
+		{name: "zero length", length: 0, alphabet: DigitsAlphabet, err: ErrInvalidCodeLength},
+		{name: "negative length", length: -1, alphabet: DigitsAlphabet, err: ErrInvalidCodeLength},
+		{name: "empty alphabet", length: 6, alphabet: "", err: ErrInvalidAlphabet},
+		{name: "single symbol", length: 6, alphabet: "A", err: ErrInvalidAlphabet},
+		{name: "duplicate symbol", length: 6, alphabet: "0123456789A0", err: ErrInvalidAlphabet},
+		{name: "over 256 symbols", length: 6, alphabet: allBytes() + "0", err: ErrInvalidAlphabet},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator, err := NewCodeGenerator(test.length, test.alphabet)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				assert.Nil(t, generator)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, generator)
+		})
+	}
+}
+
+func TestGenerateLengthAndAlphabet(t *testing.T) {
+	for _, length := range []int{1, 6, 8, 64} {
+		generator, err := NewCodeGenerator(length, CrockfordAlphabet)
+		require.NoError(t, err)
+		for range 100 {
+			code, err := generator.Generate()
+			require.NoError(t, err)
+			require.Len(t, code, length)
+			for _, symbol := range code {
+				require.True(t, strings.ContainsRune(CrockfordAlphabet, symbol), "%q is not in the alphabet", symbol)
+			}
+		}
+	}
+}
+
+// TestGenerateDistribution checks that no symbol is drawn far more or less often than the others,
+// which a modulo without rejection sampling would do on alphabets that don't divide 256
+func TestGenerateDistribution(t *testing.T) {
+	for _, alphabet := range []string{DigitsAlphabet, CrockfordAlphabet} {
+		t.Run(alphabet, func(t *testing.T) {
+			generator, err := NewCodeGenerator(100, alphabet)
+			require.NoError(t, err)
+			counts := map[rune]int{}
+			draws := 0
+			for range 1000 {
+				code, err := generator.Generate()
+				require.NoError(t, err)
+				for _, symbol := range code {
+					counts[symbol]++
+					draws++
+				}
+			}
+
+			require.Len(t, counts, len(alphabet))
+			expected := float64(draws) / float64(len(alphabet))
+			for symbol, count := range counts {
+				// 100000 draws keep every symbol well within 10% of its expected count
+				assert.InDelta(t, expected, float64(count), expected*0.1, "symbol %q", symbol)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	crockford, err := NewCodeGenerator(8, CrockfordAlphabet)
+	require.NoError(t, err)
+	digits, err := NewCodeGenerator(6, DigitsAlphabet)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		generator *CodeGenerator
+		code      string
+		expected  string
+	}{
+		{name: "crockford as is", generator: crockford, code: "AB12CD34", expected: "AB12CD34"},
+		{name: "crockford lowercase", generator: crockford, code: "ab12cd34", expected: "AB12CD34"},
+		{name: "crockford I and L", generator: crockford, code: "IL2CD34A", expected: "112CD34A"},
+		{name: "crockford lowercase i and l", generator: crockford, code: "il2cd34a", expected: "112CD34A"},
+		{name: "crockford O", generator: crockford, code: "oO2CD34A", expected: "002CD34A"},
+		{name: "crockford separators", generator: crockford, code: " AB12-CD34 ", expected: "AB12CD34"},
+		{name: "digits separators", generator: digits, code: " 123 456 ", expected: "123456"},
+		{name: "digits leave letters alone", generator: digits, code: "12345O", expected: "12345O"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.generator.Normalize(test.code))
+		})
+	}
+}
@@ -7,19 +7,21 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// CookieName returns the name the cookie is actually set with, as token cookies can be renamed in the config
+func CookieName(key string, config *config.CookiesConfig) string {
+	// we are doing this because we don't want our user clients to have conflicting cookies
+	if key == "access_token" {
+		return config.Auth.Name() // as the other services read it
+	} else if key == "refresh_token" && config.Auth.Enabled {
+		return config.Auth.RefreshToken
+	}
+	return key
+}
+
 func Cookie(key string, value string, config *config.CookiesConfig) *fiber.Cookie {
 	cookie := new(fiber.Cookie)
 
-	// will be overriden if auth is enabled
-	cookie.Name = key
-	// we are doing this because we don't want our user clients to have conflicting cookies
-	if config.Auth.Enabled {
-		if key == "access_token" {
-			cookie.Name = config.Auth.AccessToken
-		} else if key == "refresh_token" {
-			cookie.Name = config.Auth.RefreshToken
-		}
-	}
+	cookie.Name = CookieName(key, config)
 
 	cookie.HTTPOnly = config.HTTPOnly
 	cookie.Value = value
@@ -34,14 +36,7 @@ func Cookie(key string, value string, config *config.CookiesConfig) *fiber.Cooki
 func ClearCookie(key string, env string, config *config.CookiesConfig) *fiber.Cookie {
 	cookie := new(fiber.Cookie)
 
-	cookie.Name = key
-	if config.Auth.Enabled {
-		if key == "access_token" {
-			cookie.Name = config.Auth.AccessToken
-		} else if key == "refresh_token" {
-			cookie.Name = config.Auth.RefreshToken
-		}
-	}
+	cookie.Name = CookieName(key, config)
 
 	cookie.Value = ""
 	cookie.Expires = time.Now().Add(-time.Hour)
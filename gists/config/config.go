@@ -0,0 +1,73 @@
+package config
+
+import (
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Port     string `mapstructure:"port"`
+	Database struct {
+		Host     string `mapstructure:"host"`
+		Port     int    `mapstructure:"port"`
+		User     string `mapstructure:"user"`
+		Password string `mapstructure:"password"`
+		Database string `mapstructure:"database"`
+	}
+	Auth    AuthConfig  `mapstructure:"auth"`
+	Gists   GistsConfig `mapstructure:"gists"`
+	Cookies struct {
+		Auth verifier.Cookies `mapstructure:"auth"` // the cookie holding the access token, named as in the auth service
+	} `mapstructure:"cookies"`
+}
+
+// AuthConfig points to the auth service, which issues the access tokens the gists service accepts and manages the users.
+// Every token is introspected by the auth service, which tells whether it was revoked, and the users are resolved by it,
+// the gists service calling it as an OAuth client granted the tokens:introspect and users:read scopes.
+type AuthConfig struct {
+	JWKSURL          string `mapstructure:"jwks_url"` // where the public keys verifying the access tokens are published
+	Issuer           string `mapstructure:"issuer"`   // of the access tokens, as set in the auth service
+	Audience         string `mapstructure:"audience"` // the access tokens must be meant for, one of the audiences of the auth service
+	IntrospectionURL string `mapstructure:"introspection_url"`
+	UsersURL         string `mapstructure:"users_url"` // where the users are resolved, by appending their id
+	TokenURL         string `mapstructure:"token_url"`
+	ClientID         string `mapstructure:"client_id"`
+	ClientSecret     string `mapstructure:"client_secret"`
+}
+
+// GistsConfig limits the size of the gists, the sizes being in bytes
+type GistsConfig struct {
+	MaxFiles    int `mapstructure:"max_files"`
+	MaxFileSize int `mapstructure:"max_file_size"`
+	MaxSize     int `mapstructure:"max_size"` // of all the files of a gist together
+}
+
+func LoadConfig() {
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+	viper.SetConfigType("json")
+	viper.AutomaticEnv()
+	viper.SetDefault("auth.jwks_url", "http://localhost:4000/.well-known/jwks.json")
+	viper.SetDefault("auth.issuer", "gists")
+	viper.SetDefault("auth.audience", "gists")
+	viper.SetDefault("auth.introspection_url", "http://localhost:4000/internal/tokens/introspect")
+	viper.SetDefault("auth.users_url", "http://localhost:4000/internal/users")
+	viper.SetDefault("auth.token_url", "http://localhost:4000/oauth/token")
+	viper.SetDefault("gists.max_files", 20)
+	viper.SetDefault("gists.max_file_size", 1<<20)
+	viper.SetDefault("gists.max_size", 3<<20) // requests are limited to 4MB
+	err := viper.ReadInConfig()
+
+	if err != nil {
+		panic("Error reading config file : " + err.Error())
+	}
+}
+
+func GetConfig() Config {
+	var config Config
+	err := viper.Unmarshal(&config)
+	if err != nil {
+		panic("Error unmarshalling config : " + err.Error())
+	}
+	return config
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/gistsapp/api/types"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// lines of context around the changes
+const diffContext = 3
+
+// the most lines a file can have, before and after together, to be diffed: matching lines takes quadratic time at worst
+const maxDiffLines = 20000
+
+// the marker git adds after the last line of a file when it has no line break, see diff(1)
+const noNewline = "\\ No newline at end of file\n"
+
+// Diff returns the unified diff turning the files from into the files to, file by file in the order of their names.
+// A renamed file shows as removed under its old name and added under the new one.
+func Diff(from []*types.GistFile, to []*types.GistFile) (string, error) {
+	from_files := map[string]string{}
+	to_files := map[string]string{}
+	names := []string{}
+	for _, file := range from {
+		from_files[file.Name] = file.Content
+		names = append(names, file.Name)
+	}
+	for _, file := range to {
+		to_files[file.Name] = file.Content
+		if _, ok := from_files[file.Name]; !ok {
+			names = append(names, file.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var diff strings.Builder
+	for _, name := range names {
+		from_content, in_from := from_files[name]
+		to_content, in_to := to_files[name]
+		if in_from && in_to && from_content == to_content {
+			continue
+		}
+
+		from_lines := lines(from_content)
+		to_lines := lines(to_content)
+		if len(from_lines)+len(to_lines) > maxDiffLines {
+			return "", ErrDiffTooLarge
+		}
+		unified := difflib.UnifiedDiff{
+			A:        from_lines,
+			B:        to_lines,
+			FromFile: "a/" + name,
+			ToFile:   "b/" + name,
+			Context:  diffContext,
+		}
+		if !in_from {
+			unified.FromFile = "/dev/null"
+		}
+		if !in_to {
+			unified.ToFile = "/dev/null"
+		}
+		if len(from_lines) == 0 && len(to_lines) == 0 {
+			// an empty file has no line to diff, difflib would leave out its addition or removal altogether
+			diff.WriteString("--- " + unified.FromFile + "\n+++ " + unified.ToFile + "\n")
+			continue
+		}
+		if err := difflib.WriteUnifiedDiff(&diff, unified); err != nil {
+			return "", err
+		}
+	}
+	return diff.String(), nil
+}
+
+// lines splits the content in lines ending with their line break.
+// The last line is followed by the no newline marker when it has none, so that it differs from the same line with a line break.
+func lines(content string) []string {
+	split := strings.SplitAfter(content, "\n")
+	if split[len(split)-1] == "" {
+		return split[:len(split)-1]
+	}
+	split[len(split)-1] += "\n" + noNewline
+	return split
+}
+
+var ErrDiffTooLarge error = errors.New("The files are too large to be diffed")
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gistsapp/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gistFiles makes the files from their names and contents, in turn
+func gistFiles(name_contents ...string) []*types.GistFile {
+	files := []*types.GistFile{}
+	for i := 0; i < len(name_contents); i += 2 {
+		files = append(files, &types.GistFile{Name: name_contents[i], Content: name_contents[i+1]})
+	}
+	return files
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from []*types.GistFile
+		to   []*types.GistFile
+		diff string
+	}{
+		{
+			name: "unchanged",
+			from: gistFiles("main.go", "package main\n"),
+			to:   gistFiles("main.go", "package main\n"),
+			diff: "",
+		},
+		{
+			name: "changed line",
+			from: gistFiles("main.go", "package main\n\nfunc main() {\n}\n"),
+			to:   gistFiles("main.go", "package main\n\nfunc main() {\n\tprintln()\n}\n"),
+			diff: "--- a/main.go\n" +
+				"+++ b/main.go\n" +
+				"@@ -1,4 +1,5 @@\n" +
+				" package main\n" +
+				" \n" +
+				" func main() {\n" +
+				"+\tprintln()\n" +
+				" }\n",
+		},
+		{
+			name: "line break added at the end",
+			from: gistFiles("notes.txt", "first\nsecond"),
+			to:   gistFiles("notes.txt", "first\nsecond\n"),
+			diff: "--- a/notes.txt\n" +
+				"+++ b/notes.txt\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" first\n" +
+				"-second\n" +
+				"\\ No newline at end of file\n" +
+				"+second\n",
+		},
+		{
+			name: "line added after a last line without line break",
+			from: gistFiles("notes.txt", "first"),
+			to:   gistFiles("notes.txt", "first\nsecond"),
+			diff: "--- a/notes.txt\n" +
+				"+++ b/notes.txt\n" +
+				"@@ -1 +1,2 @@\n" +
+				"-first\n" +
+				"\\ No newline at end of file\n" +
+				"+first\n" +
+				"+second\n" +
+				"\\ No newline at end of file\n",
+		},
+		{
+			name: "added file",
+			from: gistFiles("a.txt", "a\n"),
+			to:   gistFiles("a.txt", "a\n", "b.txt", "b\n"),
+			diff: "--- /dev/null\n" +
+				"+++ b/b.txt\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+b\n",
+		},
+		{
+			name: "removed file",
+			from: gistFiles("a.txt", "a\n", "b.txt", "b\n"),
+			to:   gistFiles("a.txt", "a\n"),
+			diff: "--- a/b.txt\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-b\n",
+		},
+		{
+			name: "added empty file",
+			from: gistFiles("a.txt", "a\n"),
+			to:   gistFiles("a.txt", "a\n", "empty", ""),
+			diff: "--- /dev/null\n" +
+				"+++ b/empty\n",
+		},
+		{
+			name: "removed empty file",
+			from: gistFiles("a.txt", "a\n", "empty", ""),
+			to:   gistFiles("a.txt", "a\n"),
+			diff: "--- a/empty\n" +
+				"+++ /dev/null\n",
+		},
+		{
+			name: "renamed file",
+			from: gistFiles("old.txt", "content\n"),
+			to:   gistFiles("new.txt", "content\n"),
+			diff: "--- /dev/null\n" +
+				"+++ b/new.txt\n" +
+				"@@ -0,0 +1 @@\n" +
+				"+content\n" +
+				"--- a/old.txt\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-content\n",
+		},
+		{
+			name: "emptied file",
+			from: gistFiles("a.txt", "a\n"),
+			to:   gistFiles("a.txt", ""),
+			diff: "--- a/a.txt\n" +
+				"+++ b/a.txt\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-a\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff, err := Diff(test.from, test.to)
+			require.NoError(t, err)
+			assert.Equal(t, test.diff, diff)
+		})
+	}
+}
+
+func TestDiffTooLarge(t *testing.T) {
+	half := strings.Repeat("line\n", maxDiffLines/2)
+	_, err := Diff(gistFiles("a.txt", half), gistFiles("a.txt", half+"line\n"))
+	assert.Equal(t, ErrDiffTooLarge, err)
+
+	// unchanged files aren't diffed, whatever their size
+	diff, err := Diff(gistFiles("a.txt", half+half, "b.txt", "b\n"), gistFiles("a.txt", half+half, "b.txt", "c\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-b\n+c\n", diff)
+
+	_, err = Diff(gistFiles("a.txt", half), gistFiles("a.txt", half[len("line\n"):]))
+	assert.NoError(t, err, "up to maxDiffLines lines are diffed")
+}
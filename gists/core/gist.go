@@ -0,0 +1,377 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/gistsapp/api/gists/config"
+	"github.com/gistsapp/api/gists/repositories"
+	"github.com/gistsapp/api/types"
+)
+
+// GistService manages the gists of the users, who only ever see their own and the ones of their organizations:
+// the other gists are reported as not found.
+// The members of an organization read its gists as viewers, edit them as editors, and delete them as owners.
+type GistService interface {
+	Create(user_id string, organization_id *string, name string, files []*types.GistFile) (*types.Gist, error)
+	Get(user_id string, id string) (*types.Gist, error)
+	List(user_id string, limit int, offset int) ([]*types.Gist, error)
+	ListOrganization(user_id string, organization_id string, limit int, offset int) ([]*types.Gist, error)
+	Rename(user_id string, id string, name string) (*types.Gist, error)
+	Delete(user_id string, id string) error
+	AddFile(user_id string, id string, file *types.GistFile) (*types.GistFile, error)
+	UpdateFile(user_id string, id string, name string, new_name *string, content *string) (*types.GistFile, error)
+	RemoveFile(user_id string, id string, name string) error
+	Revisions(user_id string, id string, limit int, offset int) ([]*types.GistRevision, error)
+	Revision(user_id string, id string, number int) (*types.GistRevision, error)
+	Diff(user_id string, id string, from int, to int) (string, error)
+	Restore(user_id string, id string, number int) (*types.Gist, error)
+}
+
+type gistService struct {
+	db     repositories.Database
+	config config.GistsConfig
+}
+
+func NewGistService(db repositories.Database, config config.GistsConfig) GistService {
+	return &gistService{
+		db:     db,
+		config: config,
+	}
+}
+
+// Create creates a gist owned by the user, or by the organization when organization_id is given
+func (g *gistService) Create(user_id string, organization_id *string, name string, files []*types.GistFile) (*types.Gist, error) {
+	if organization_id != nil {
+		if _, err := memberRole(g.db, *organization_id, user_id, types.RoleEditor); err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+	names := map[string]bool{}
+	for _, file := range files {
+		if names[file.Name] {
+			return nil, ErrFileExists
+		}
+		names[file.Name] = true
+	}
+	if err := g.checkFiles(files); err != nil {
+		return nil, err
+	}
+
+	gist, err := g.db.CreateGist(&types.Gist{
+		UserID:         user_id,
+		OrganizationID: organization_id,
+		Name:           name,
+		Files:          files,
+	})
+	if err == repositories.ErrDuplicateName {
+		return nil, ErrFileExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return describe(gist), nil
+}
+
+func (g *gistService) Get(user_id string, id string) (*types.Gist, error) {
+	return g.access(user_id, id, types.RoleViewer)
+}
+
+// List lists the personal gists of the user, the gists of their organizations being listed by organization
+func (g *gistService) List(user_id string, limit int, offset int) ([]*types.Gist, error) {
+	gists, err := g.db.GetGistsByUserID(user_id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for _, gist := range gists {
+		describe(gist)
+	}
+	return gists, nil
+}
+
+func (g *gistService) ListOrganization(user_id string, organization_id string, limit int, offset int) ([]*types.Gist, error) {
+	if _, err := memberRole(g.db, organization_id, user_id, types.RoleViewer); err != nil {
+		return nil, err
+	}
+	gists, err := g.db.GetGistsByOrganizationID(organization_id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for _, gist := range gists {
+		describe(gist)
+	}
+	return gists, nil
+}
+
+// access returns the gist when the user has at least the required role on it.
+// Users own their personal gists, and have their role in the organization on the gists of an organization.
+func (g *gistService) access(user_id string, id string, required string) (*types.Gist, error) {
+	gist, err := g.db.GetGistByID(id)
+	if err == sql.ErrNoRows {
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if gist.OrganizationID == nil {
+		if gist.UserID != user_id {
+			return nil, types.ErrNotFound
+		}
+		return describe(gist), nil
+	}
+	if _, err := memberRole(g.db, *gist.OrganizationID, user_id, required); err != nil {
+		return nil, err
+	}
+	return describe(gist), nil
+}
+
+func (g *gistService) Rename(user_id string, id string, name string) (*types.Gist, error) {
+	gist, err := g.access(user_id, id, types.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	gist.Name = name
+
+	renamed, err := g.db.UpdateGist(gist, user_id)
+	if err == sql.ErrNoRows { // deleted in the meantime
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	renamed.Files = gist.Files
+	return renamed, nil
+}
+
+func (g *gistService) Delete(user_id string, id string) error {
+	if _, err := g.access(user_id, id, types.RoleOwner); err != nil {
+		return err
+	}
+	err := g.db.DeleteGist(id)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// AddFile adds a file to the gist, whose other files are left as they are
+func (g *gistService) AddFile(user_id string, id string, file *types.GistFile) (*types.GistFile, error) {
+	gist, err := g.access(user_id, id, types.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if findFile(gist, file.Name) != nil {
+		return nil, ErrFileExists
+	}
+
+	// the limits are checked against the files of the gist once it is locked, as concurrent edits may have added some
+	file.GistID = gist.ID
+	created, err := g.db.CreateGistFile(file, user_id, g.checkFiles)
+	if err == sql.ErrNoRows { // deleted in the meantime
+		return nil, types.ErrNotFound
+	}
+	if err == repositories.ErrDuplicateName {
+		return nil, ErrFileExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return describeFile(created), nil
+}
+
+// UpdateFile renames the file called name to new_name and replaces its content, when they are given
+func (g *gistService) UpdateFile(user_id string, id string, name string, new_name *string, content *string) (*types.GistFile, error) {
+	gist, err := g.access(user_id, id, types.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	file := findFile(gist, name)
+	if file == nil {
+		return nil, types.ErrNotFound
+	}
+	if new_name != nil && *new_name != name {
+		if findFile(gist, *new_name) != nil {
+			return nil, ErrFileExists
+		}
+		file.Name = *new_name
+	}
+	if content != nil {
+		file.Content = *content
+		file.Size = len(*content)
+	}
+
+	updated, err := g.db.UpdateGistFile(name, file, user_id, g.checkFiles)
+	if err == sql.ErrNoRows { // removed in the meantime
+		return nil, types.ErrNotFound
+	}
+	if err == repositories.ErrDuplicateName {
+		return nil, ErrFileExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return describeFile(updated), nil
+}
+
+// RemoveFile removes a file from the gist, which must keep at least one
+func (g *gistService) RemoveFile(user_id string, id string, name string) error {
+	gist, err := g.access(user_id, id, types.RoleEditor)
+	if err != nil {
+		return err
+	}
+	if findFile(gist, name) == nil {
+		return types.ErrNotFound
+	}
+
+	// checked once the gist is locked, as a concurrent removal may have left this file alone
+	err = g.db.DeleteGistFile(gist.ID, name, user_id, keepOneFile)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// Revisions lists the revisions of the gist, the latest first, without their files
+func (g *gistService) Revisions(user_id string, id string, limit int, offset int) ([]*types.GistRevision, error) {
+	gist, err := g.Get(user_id, id)
+	if err != nil {
+		return nil, err
+	}
+	return g.db.GetGistRevisions(gist.ID, limit, offset)
+}
+
+// Revision returns the gist as it was at the revision
+func (g *gistService) Revision(user_id string, id string, number int) (*types.GistRevision, error) {
+	gist, err := g.Get(user_id, id)
+	if err != nil {
+		return nil, err
+	}
+	return g.revision(gist, number)
+}
+
+func (g *gistService) revision(gist *types.Gist, number int) (*types.GistRevision, error) {
+	revision, err := g.db.GetGistRevision(gist.ID, number)
+	if err == sql.ErrNoRows {
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range revision.Files {
+		describeFile(file)
+	}
+	return revision, nil
+}
+
+// Diff returns the unified diff of the files of the gist, from a revision to another, the latest one when to is 0
+func (g *gistService) Diff(user_id string, id string, from int, to int) (string, error) {
+	if to == 0 {
+		gist, err := g.Get(user_id, id)
+		if err != nil {
+			return "", err
+		}
+		to = gist.Revision
+	}
+	from_revision, err := g.Revision(user_id, id, from)
+	if err != nil {
+		return "", err
+	}
+	to_revision, err := g.Revision(user_id, id, to)
+	if err != nil {
+		return "", err
+	}
+	return Diff(from_revision.Files, to_revision.Files)
+}
+
+// Restore puts the gist back as it was at the revision, as a new revision: the revisions in between are kept
+func (g *gistService) Restore(user_id string, id string, number int) (*types.Gist, error) {
+	gist, err := g.access(user_id, id, types.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := g.revision(gist, number)
+	if err != nil {
+		return nil, err
+	}
+	// the limits may have been lowered since
+	if err := g.checkFiles(revision.Files); err != nil {
+		return nil, err
+	}
+
+	gist, err = g.db.RestoreGistRevision(revision, user_id)
+	if err == sql.ErrNoRows { // deleted in the meantime
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return describe(gist), nil
+}
+
+// checkFiles checks the names of the files, and that they fit in the limits of a gist
+func (g *gistService) checkFiles(files []*types.GistFile) error {
+	if len(files) > g.config.MaxFiles {
+		return ErrTooManyFiles
+	}
+	size := 0
+	for _, file := range files {
+		if !validFileName(file.Name) {
+			return ErrInvalidFileName
+		}
+		if len(file.Content) > g.config.MaxFileSize {
+			return ErrFileTooLarge
+		}
+		size += len(file.Content)
+	}
+	if size > g.config.MaxSize {
+		return ErrGistTooLarge
+	}
+	return nil
+}
+
+// keepOneFile refuses to leave a gist without any file
+func keepOneFile(files []*types.GistFile) error {
+	if len(files) == 0 {
+		return ErrLastFile
+	}
+	return nil
+}
+
+// validFileName rejects the names that can't be used as is in a path
+func validFileName(name string) bool {
+	return name != "" && len(name) <= 255 && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func findFile(gist *types.Gist, name string) *types.GistFile {
+	for _, file := range gist.Files {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+// describe detects the language of the files of the gist
+func describe(gist *types.Gist) *types.Gist {
+	for _, file := range gist.Files {
+		describeFile(file)
+	}
+	return gist
+}
+
+func describeFile(file *types.GistFile) *types.GistFile {
+	file.Language = DetectLanguage(file.Name)
+	return file
+}
+
+var ErrNoFiles error = errors.New("A gist needs at least one file")
+var ErrLastFile error = errors.New("The last file of a gist can't be removed")
+var ErrFileExists error = errors.New("The gist already has a file with this name")
+var ErrInvalidFileName error = errors.New("File names can't be empty, . or .., nor contain slashes")
+var ErrTooManyFiles error = errors.New("Too many files in the gist")
+var ErrFileTooLarge error = errors.New("File too large")
+var ErrGistTooLarge error = errors.New("Gist too large")
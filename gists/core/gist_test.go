@@ -0,0 +1,544 @@
+package core
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gistsapp/api/gists/config"
+	"github.com/gistsapp/api/gists/repositories"
+	"github.com/gistsapp/api/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryDatabase keeps the gists, their revisions and the organizations in memory, every edit making a revision as in the database.
+// The other methods of repositories.Database aren't implemented, calling them fails the test with a panic.
+type memoryDatabase struct {
+	repositories.Database
+	gists         map[string]*types.Gist
+	revisions     map[string][]*types.GistRevision // by gist, in the order of their numbers
+	organizations map[string]*types.Organization
+	members       []*types.OrganizationMember
+}
+
+func newMemoryDatabase() *memoryDatabase {
+	return &memoryDatabase{
+		gists:         map[string]*types.Gist{},
+		revisions:     map[string][]*types.GistRevision{},
+		organizations: map[string]*types.Organization{},
+	}
+}
+
+// copyFiles copies the files, sorted by name like the database returns them, so that the service can't change the stored ones
+func copyFiles(files []*types.GistFile) []*types.GistFile {
+	copied := []*types.GistFile{}
+	for _, file := range files {
+		copied = append(copied, &types.GistFile{GistID: file.GistID, Name: file.Name, Content: file.Content, Size: len(file.Content)})
+	}
+	slices.SortFunc(copied, func(a, b *types.GistFile) int { return strings.Compare(a.Name, b.Name) })
+	return copied
+}
+
+func copyGist(gist *types.Gist) *types.Gist {
+	copied := *gist
+	copied.Files = copyFiles(gist.Files)
+	return &copied
+}
+
+// revise makes a revision of the gist as it is now
+func (m *memoryDatabase) revise(gist *types.Gist, author_id string) {
+	gist.Revision++
+	m.revisions[gist.ID] = append(m.revisions[gist.ID], &types.GistRevision{
+		GistID: gist.ID,
+		Number: gist.Revision,
+		UserID: author_id,
+		Name:   gist.Name,
+		Files:  copyFiles(gist.Files),
+	})
+}
+
+func (m *memoryDatabase) CreateGist(gist *types.Gist) (*types.Gist, error) {
+	created := copyGist(gist)
+	created.ID = uuid.New().String()
+	created.Revision = 0
+	for i, file := range created.Files {
+		file.GistID = created.ID
+		if i > 0 && created.Files[i-1].Name == file.Name {
+			return nil, repositories.ErrDuplicateName
+		}
+	}
+	m.gists[created.ID] = created
+	m.revise(created, gist.UserID)
+	return copyGist(created), nil
+}
+
+func (m *memoryDatabase) GetGistByID(id string) (*types.Gist, error) {
+	gist, ok := m.gists[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyGist(gist), nil
+}
+
+func (m *memoryDatabase) UpdateGist(gist *types.Gist, author_id string) (*types.Gist, error) {
+	stored, ok := m.gists[gist.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	stored.Name = gist.Name
+	m.revise(stored, author_id)
+	updated := *stored
+	updated.Files = nil
+	return &updated, nil
+}
+
+func (m *memoryDatabase) DeleteGist(id string) error {
+	if _, ok := m.gists[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.gists, id)
+	delete(m.revisions, id)
+	return nil
+}
+
+func (m *memoryDatabase) CreateGistFile(file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error) {
+	gist, ok := m.gists[file.GistID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	files := append(copyFiles(gist.Files), file)
+	if err := check(files); err != nil {
+		return nil, err
+	}
+	for _, existing := range gist.Files {
+		if existing.Name == file.Name {
+			return nil, repositories.ErrDuplicateName
+		}
+	}
+	gist.Files = copyFiles(files)
+	m.revise(gist, author_id)
+	return copyFiles([]*types.GistFile{file})[0], nil
+}
+
+func (m *memoryDatabase) UpdateGistFile(name string, file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error) {
+	gist, ok := m.gists[file.GistID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	files := copyFiles(gist.Files)
+	found := false
+	for i, existing := range files {
+		if existing.Name == name {
+			files[i] = file
+			found = true
+		} else if existing.Name == file.Name {
+			return nil, repositories.ErrDuplicateName
+		}
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	if err := check(files); err != nil {
+		return nil, err
+	}
+	gist.Files = copyFiles(files)
+	m.revise(gist, author_id)
+	return copyFiles([]*types.GistFile{file})[0], nil
+}
+
+func (m *memoryDatabase) DeleteGistFile(gist_id string, name string, author_id string, check func(files []*types.GistFile) error) error {
+	gist, ok := m.gists[gist_id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	remaining := []*types.GistFile{}
+	for _, file := range gist.Files {
+		if file.Name != name {
+			remaining = append(remaining, file)
+		}
+	}
+	if len(remaining) == len(gist.Files) {
+		return sql.ErrNoRows
+	}
+	if err := check(remaining); err != nil {
+		return err
+	}
+	gist.Files = remaining
+	m.revise(gist, author_id)
+	return nil
+}
+
+func (m *memoryDatabase) RestoreGistRevision(revision *types.GistRevision, author_id string) (*types.Gist, error) {
+	gist, ok := m.gists[revision.GistID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	gist.Name = revision.Name
+	gist.Files = copyFiles(revision.Files)
+	m.revise(gist, author_id)
+	return copyGist(gist), nil
+}
+
+func (m *memoryDatabase) GetGistRevisions(gist_id string, limit int, offset int) ([]*types.GistRevision, error) {
+	revisions := []*types.GistRevision{}
+	for i := len(m.revisions[gist_id]) - 1 - offset; i >= 0 && len(revisions) < limit; i-- {
+		revision := *m.revisions[gist_id][i]
+		revision.Files = nil
+		revisions = append(revisions, &revision)
+	}
+	return revisions, nil
+}
+
+func (m *memoryDatabase) GetGistRevision(gist_id string, number int) (*types.GistRevision, error) {
+	for _, revision := range m.revisions[gist_id] {
+		if revision.Number == number {
+			found := *revision
+			found.Files = copyFiles(revision.Files)
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryDatabase) CreateOrganization(organization *types.Organization, owner_id string) (*types.Organization, error) {
+	created := *organization
+	created.ID = uuid.New().String()
+	m.organizations[created.ID] = &created
+	m.members = append(m.members, &types.OrganizationMember{OrganizationID: created.ID, UserID: owner_id, Role: types.RoleOwner})
+	return &created, nil
+}
+
+func (m *memoryDatabase) GetOrganizationByID(id string) (*types.Organization, error) {
+	organization, ok := m.organizations[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *organization
+	return &found, nil
+}
+
+func (m *memoryDatabase) GetOrganizationMember(organization_id string, user_id string) (*types.OrganizationMember, error) {
+	for _, member := range m.members {
+		if member.OrganizationID == organization_id && member.UserID == user_id {
+			found := *member
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryDatabase) GetOrganizationMembers(organization_id string) ([]*types.OrganizationMember, error) {
+	members := []*types.OrganizationMember{}
+	for _, member := range m.members {
+		if member.OrganizationID == organization_id {
+			found := *member
+			members = append(members, &found)
+		}
+	}
+	return members, nil
+}
+
+func (m *memoryDatabase) owners(organization_id string) []*types.OrganizationMember {
+	owners := []*types.OrganizationMember{}
+	for _, member := range m.members {
+		if member.OrganizationID == organization_id && member.Role == types.RoleOwner {
+			owners = append(owners, member)
+		}
+	}
+	return owners
+}
+
+func (m *memoryDatabase) SetOrganizationMember(member *types.OrganizationMember, check func(owners []*types.OrganizationMember) error) (*types.OrganizationMember, error) {
+	if err := check(m.owners(member.OrganizationID)); err != nil {
+		return nil, err
+	}
+	set := *member
+	for i, existing := range m.members {
+		if existing.OrganizationID == member.OrganizationID && existing.UserID == member.UserID {
+			m.members[i] = &set
+			return member, nil
+		}
+	}
+	m.members = append(m.members, &set)
+	return member, nil
+}
+
+func (m *memoryDatabase) DeleteOrganizationMember(organization_id string, user_id string, check func(owners []*types.OrganizationMember) error) error {
+	if err := check(m.owners(organization_id)); err != nil {
+		return err
+	}
+	for i, member := range m.members {
+		if member.OrganizationID == organization_id && member.UserID == user_id {
+			m.members = slices.Delete(m.members, i, i+1)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// testLimits allows two files of at most 10 bytes, 15 bytes together
+var testLimits = config.GistsConfig{MaxFiles: 2, MaxFileSize: 10, MaxSize: 15}
+
+func TestCreateChecksFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []*types.GistFile
+		err   error
+	}{
+		{name: "valid", files: gistFiles("main.go", "package a\n", "README", "hello")},
+		{name: "no files", files: gistFiles(), err: ErrNoFiles},
+		{name: "same name twice", files: gistFiles("a", "a", "a", "b"), err: ErrFileExists},
+		{name: "too many files", files: gistFiles("a", "a", "b", "b", "c", "c"), err: ErrTooManyFiles},
+		{name: "file too large", files: gistFiles("a", strings.Repeat("a", 11)), err: ErrFileTooLarge},
+		{name: "gist too large", files: gistFiles("a", strings.Repeat("a", 10), "b", strings.Repeat("b", 6)), err: ErrGistTooLarge},
+		{name: "empty name", files: gistFiles("", "a"), err: ErrInvalidFileName},
+		{name: "dot", files: gistFiles(".", "a"), err: ErrInvalidFileName},
+		{name: "dot dot", files: gistFiles("..", "a"), err: ErrInvalidFileName},
+		{name: "slash", files: gistFiles("src/main.go", "a"), err: ErrInvalidFileName},
+		{name: "backslash", files: gistFiles("src\\main.go", "a"), err: ErrInvalidFileName},
+		{name: "null byte", files: gistFiles("main\x00.go", "a"), err: ErrInvalidFileName},
+		{name: "name too long", files: gistFiles(strings.Repeat("a", 256), "a"), err: ErrInvalidFileName},
+		{name: "longest name", files: gistFiles(strings.Repeat("a", 255), "a")},
+		{name: "dots in the name", files: gistFiles("..hidden.", "a")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gists := NewGistService(newMemoryDatabase(), testLimits)
+			gist, err := gists.Create("user", nil, "gist", test.files)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 1, gist.Revision)
+			assert.Len(t, gist.Files, len(test.files))
+		})
+	}
+}
+
+func TestEditChecksFiles(t *testing.T) {
+	long := strings.Repeat("a", 11)
+	tests := []struct {
+		name string
+		edit func(gists GistService, id string) error
+		err  error
+	}{
+		{name: "add", edit: func(gists GistService, id string) error {
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: "b"})
+			return err
+		}},
+		{name: "add existing", edit: func(gists GistService, id string) error {
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "a", Content: "b"})
+			return err
+		}, err: ErrFileExists},
+		{name: "add invalid name", edit: func(gists GistService, id string) error {
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "../b", Content: "b"})
+			return err
+		}, err: ErrInvalidFileName},
+		{name: "add too large", edit: func(gists GistService, id string) error {
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: long})
+			return err
+		}, err: ErrFileTooLarge},
+		{name: "add beyond the size of the gist", edit: func(gists GistService, id string) error {
+			content := strings.Repeat("a", 6)
+			if _, err := gists.UpdateFile("user", id, "a", nil, &content); err != nil {
+				return err
+			}
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: strings.Repeat("b", 10)})
+			return err
+		}, err: ErrGistTooLarge},
+		{name: "add beyond the files of the gist", edit: func(gists GistService, id string) error {
+			if _, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: "b"}); err != nil {
+				return err
+			}
+			_, err := gists.AddFile("user", id, &types.GistFile{Name: "c", Content: "c"})
+			return err
+		}, err: ErrTooManyFiles},
+		{name: "update", edit: func(gists GistService, id string) error {
+			name, content := "b", "b"
+			_, err := gists.UpdateFile("user", id, "a", &name, &content)
+			return err
+		}},
+		{name: "update unknown", edit: func(gists GistService, id string) error {
+			content := "b"
+			_, err := gists.UpdateFile("user", id, "b", nil, &content)
+			return err
+		}, err: types.ErrNotFound},
+		{name: "rename to another file", edit: func(gists GistService, id string) error {
+			if _, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: "b"}); err != nil {
+				return err
+			}
+			name := "b"
+			_, err := gists.UpdateFile("user", id, "a", &name, nil)
+			return err
+		}, err: ErrFileExists},
+		{name: "rename to an invalid name", edit: func(gists GistService, id string) error {
+			name := "."
+			_, err := gists.UpdateFile("user", id, "a", &name, nil)
+			return err
+		}, err: ErrInvalidFileName},
+		{name: "update too large", edit: func(gists GistService, id string) error {
+			_, err := gists.UpdateFile("user", id, "a", nil, &long)
+			return err
+		}, err: ErrFileTooLarge},
+		{name: "remove", edit: func(gists GistService, id string) error {
+			if _, err := gists.AddFile("user", id, &types.GistFile{Name: "b", Content: "b"}); err != nil {
+				return err
+			}
+			return gists.RemoveFile("user", id, "a")
+		}},
+		{name: "remove the last file", edit: func(gists GistService, id string) error {
+			return gists.RemoveFile("user", id, "a")
+		}, err: ErrLastFile},
+		{name: "remove unknown", edit: func(gists GistService, id string) error {
+			return gists.RemoveFile("user", id, "b")
+		}, err: types.ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newMemoryDatabase()
+			gists := NewGistService(db, testLimits)
+			gist, err := gists.Create("user", nil, "gist", gistFiles("a", "a"))
+			require.NoError(t, err)
+
+			err = test.edit(gists, gist.ID)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestGistAccess(t *testing.T) {
+	db := newMemoryDatabase()
+	gists := NewGistService(db, testLimits)
+	organization, err := db.CreateOrganization(&types.Organization{Name: "organization"}, "owner")
+	require.NoError(t, err)
+	for user_id, role := range map[string]string{"editor": types.RoleEditor, "viewer": types.RoleViewer} {
+		_, err := db.SetOrganizationMember(&types.OrganizationMember{OrganizationID: organization.ID, UserID: user_id, Role: role}, keepOwner(user_id))
+		require.NoError(t, err)
+	}
+	personal, err := gists.Create("owner", nil, "personal", gistFiles("a", "a"))
+	require.NoError(t, err)
+	shared, err := gists.Create("editor", &organization.ID, "shared", gistFiles("a", "a"))
+	require.NoError(t, err)
+	_, err = gists.Create("viewer", &organization.ID, "shared", gistFiles("a", "a"))
+	assert.Equal(t, ErrInsufficientRole, err)
+	_, err = gists.Create("stranger", &organization.ID, "shared", gistFiles("a", "a"))
+	assert.Equal(t, types.ErrNotFound, err)
+
+	get := func(user_id string, id string) error {
+		_, err := gists.Get(user_id, id)
+		return err
+	}
+	edit := func(user_id string, id string) error {
+		_, err := gists.AddFile(user_id, id, &types.GistFile{Name: user_id, Content: "b"})
+		if err == nil {
+			err = gists.RemoveFile(user_id, id, user_id)
+		}
+		return err
+	}
+	tests := []struct {
+		user   string
+		gist   *types.Gist
+		get    error
+		edit   error
+		delete error
+	}{
+		{user: "owner", gist: personal},
+		{user: "editor", gist: personal, get: types.ErrNotFound, edit: types.ErrNotFound, delete: types.ErrNotFound},
+		{user: "editor", gist: shared, delete: ErrInsufficientRole},
+		{user: "viewer", gist: shared, edit: ErrInsufficientRole, delete: ErrInsufficientRole},
+		{user: "stranger", gist: shared, get: types.ErrNotFound, edit: types.ErrNotFound, delete: types.ErrNotFound},
+		{user: "owner", gist: shared},
+	}
+	for _, test := range tests {
+		t.Run(test.user+" "+test.gist.Name, func(t *testing.T) {
+			assert.Equal(t, test.get, get(test.user, test.gist.ID))
+			assert.Equal(t, test.edit, edit(test.user, test.gist.ID))
+			if test.delete != nil {
+				assert.Equal(t, test.delete, gists.Delete(test.user, test.gist.ID))
+			}
+		})
+	}
+	require.NoError(t, gists.Delete("owner", shared.ID))
+	assert.Equal(t, types.ErrNotFound, get("owner", shared.ID))
+}
+
+func TestRevisionsAndRestore(t *testing.T) {
+	db := newMemoryDatabase()
+	gists := NewGistService(db, testLimits)
+	gist, err := gists.Create("user", nil, "gist", gistFiles("a.txt", "a\n"))
+	require.NoError(t, err)
+	_, err = gists.AddFile("user", gist.ID, &types.GistFile{Name: "b.txt", Content: "b\n"})
+	require.NoError(t, err)
+	content := "c\n"
+	_, err = gists.UpdateFile("user", gist.ID, "a.txt", nil, &content)
+	require.NoError(t, err)
+	require.NoError(t, gists.RemoveFile("user", gist.ID, "b.txt"))
+	_, err = gists.Rename("user", gist.ID, "renamed")
+	require.NoError(t, err)
+
+	revisions, err := gists.Revisions("user", gist.ID, 10, 0)
+	require.NoError(t, err)
+	numbers := []int{}
+	for _, revision := range revisions {
+		numbers = append(numbers, revision.Number)
+		assert.Nil(t, revision.Files)
+	}
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, numbers, "every edit makes a revision, the latest first")
+	revisions, err = gists.Revisions("user", gist.ID, 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 4, revisions[0].Number)
+	assert.Len(t, revisions, 2)
+
+	revision, err := gists.Revision("user", gist.ID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "gist", revision.Name)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, fileNames(revision.Files))
+	assert.Equal(t, DefaultLanguage, revision.Files[0].Language)
+	_, err = gists.Revision("user", gist.ID, 6)
+	assert.Equal(t, types.ErrNotFound, err)
+	_, err = gists.Revision("other", gist.ID, 2)
+	assert.Equal(t, types.ErrNotFound, err)
+
+	diff, err := gists.Diff("user", gist.ID, 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+c\n--- a/b.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-b\n", diff)
+
+	restored, err := gists.Restore("user", gist.ID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 6, restored.Revision, "restoring makes a new revision")
+	assert.Equal(t, "gist", restored.Name)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, fileNames(restored.Files))
+	assert.Equal(t, "a\n", restored.Files[0].Content)
+	revisions, err = gists.Revisions("user", gist.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, revisions, 6, "the revisions in between are kept")
+	diff, err = gists.Diff("user", gist.ID, 2, 6)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	_, err = gists.Restore("user", gist.ID, 7)
+	assert.Equal(t, types.ErrNotFound, err)
+	_, err = gists.Restore("other", gist.ID, 1)
+	assert.Equal(t, types.ErrNotFound, err)
+
+	// the revision doesn't fit in the limits anymore
+	lowered := NewGistService(db, config.GistsConfig{MaxFiles: 1, MaxFileSize: 10, MaxSize: 15})
+	_, err = lowered.Restore("user", gist.ID, 2)
+	assert.Equal(t, ErrTooManyFiles, err)
+}
+
+func fileNames(files []*types.GistFile) []string {
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
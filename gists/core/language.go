@@ -0,0 +1,94 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DefaultLanguage is the language of the files whose extension isn't known
+const DefaultLanguage = "Text"
+
+// languages by extension, lowercased
+var languages = map[string]string{
+	".c":          "C",
+	".h":          "C",
+	".cc":         "C++",
+	".cpp":        "C++",
+	".cxx":        "C++",
+	".hpp":        "C++",
+	".cs":         "C#",
+	".clj":        "Clojure",
+	".css":        "CSS",
+	".csv":        "CSV",
+	".dart":       "Dart",
+	".diff":       "Diff",
+	".patch":      "Diff",
+	".ex":         "Elixir",
+	".exs":        "Elixir",
+	".erl":        "Erlang",
+	".go":         "Go",
+	".graphql":    "GraphQL",
+	".hs":         "Haskell",
+	".html":       "HTML",
+	".htm":        "HTML",
+	".ini":        "INI",
+	".java":       "Java",
+	".js":         "JavaScript",
+	".mjs":        "JavaScript",
+	".cjs":        "JavaScript",
+	".jsx":        "JavaScript",
+	".json":       "JSON",
+	".kt":         "Kotlin",
+	".kts":        "Kotlin",
+	".lua":        "Lua",
+	".md":         "Markdown",
+	".markdown":   "Markdown",
+	".ml":         "OCaml",
+	".php":        "PHP",
+	".pl":         "Perl",
+	".ps1":        "PowerShell",
+	".py":         "Python",
+	".r":          "R",
+	".rb":         "Ruby",
+	".rs":         "Rust",
+	".scala":      "Scala",
+	".scss":       "SCSS",
+	".sh":         "Shell",
+	".bash":       "Shell",
+	".zsh":        "Shell",
+	".sql":        "SQL",
+	".svelte":     "Svelte",
+	".swift":      "Swift",
+	".tf":         "HCL",
+	".toml":       "TOML",
+	".ts":         "TypeScript",
+	".tsx":        "TypeScript",
+	".vue":        "Vue",
+	".xml":        "XML",
+	".yaml":       "YAML",
+	".yml":        "YAML",
+	".zig":        "Zig",
+	".txt":        DefaultLanguage,
+	".dockerfile": "Dockerfile",
+}
+
+// languages of the files known by their whole name, lowercased
+var languagesByName = map[string]string{
+	"dockerfile":     "Dockerfile",
+	"makefile":       "Makefile",
+	"justfile":       "Just",
+	"go.mod":         "Go Module",
+	"cmakelists.txt": "CMake",
+}
+
+// DetectLanguage tells the language of a file from its name
+func DetectLanguage(name string) string {
+	lower := strings.ToLower(name)
+	if language, ok := languagesByName[lower]; ok {
+		return language
+	}
+	if language, ok := languages[filepath.Ext(lower)]; ok {
+		return language
+	}
+	return DefaultLanguage
+}
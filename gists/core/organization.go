@@ -0,0 +1,176 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gistsapp/api/gists/repositories"
+	"github.com/gistsapp/api/types"
+)
+
+// the roles of the members, each one allowing what the ones below it allow
+var roleRanks = map[string]int{
+	types.RoleViewer: 1,
+	types.RoleEditor: 2,
+	types.RoleOwner:  3,
+}
+
+// OrganizationService manages the organizations and their members.
+// The organizations a user isn't a member of are reported as not found.
+type OrganizationService interface {
+	Create(user_id string, name string) (*types.Organization, error)
+	List(user_id string) ([]*types.Organization, error)
+	Get(user_id string, id string) (*types.Organization, error)
+	Delete(user_id string, id string) error
+	Members(user_id string, id string) ([]*types.OrganizationMember, error)
+	SetMember(user_id string, id string, member_id string, role string) (*types.OrganizationMember, error)
+	RemoveMember(user_id string, id string, member_id string) error
+}
+
+type organizationService struct {
+	db    repositories.Database
+	users repositories.Users
+}
+
+func NewOrganizationService(db repositories.Database, users repositories.Users) OrganizationService {
+	return &organizationService{
+		db:    db,
+		users: users,
+	}
+}
+
+// Create creates an organization whose only member, its owner, is the user
+func (o *organizationService) Create(user_id string, name string) (*types.Organization, error) {
+	return o.db.CreateOrganization(&types.Organization{
+		Name: name,
+	}, user_id)
+}
+
+func (o *organizationService) List(user_id string) ([]*types.Organization, error) {
+	return o.db.GetOrganizationsByUserID(user_id)
+}
+
+// Get returns the organization, along with the role of the user in it
+func (o *organizationService) Get(user_id string, id string) (*types.Organization, error) {
+	role, err := memberRole(o.db, id, user_id, types.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	organization, err := o.db.GetOrganizationByID(id)
+	if err == sql.ErrNoRows {
+		return nil, types.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	organization.Role = role
+	return organization, nil
+}
+
+// Delete deletes the organization along with its gists, which only its owners can do
+func (o *organizationService) Delete(user_id string, id string) error {
+	if _, err := memberRole(o.db, id, user_id, types.RoleOwner); err != nil {
+		return err
+	}
+	err := o.db.DeleteOrganization(id)
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+func (o *organizationService) Members(user_id string, id string) ([]*types.OrganizationMember, error) {
+	if _, err := memberRole(o.db, id, user_id, types.RoleViewer); err != nil {
+		return nil, err
+	}
+	return o.db.GetOrganizationMembers(id)
+}
+
+// SetMember adds a member to the organization or changes their role, which only its owners can do.
+// New members must be users of the auth service, and the organization must keep at least one owner.
+func (o *organizationService) SetMember(user_id string, id string, member_id string, role string) (*types.OrganizationMember, error) {
+	if _, ok := roleRanks[role]; !ok {
+		return nil, ErrInvalidRole
+	}
+	if _, err := memberRole(o.db, id, user_id, types.RoleOwner); err != nil {
+		return nil, err
+	}
+	if _, err := o.db.GetOrganizationMember(id, member_id); err == sql.ErrNoRows {
+		if err := o.resolveUser(member_id); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	check := func(owners []*types.OrganizationMember) error { return nil }
+	if role != types.RoleOwner {
+		check = keepOwner(member_id)
+	}
+
+	return o.db.SetOrganizationMember(&types.OrganizationMember{
+		OrganizationID: id,
+		UserID:         member_id,
+		Role:           role,
+	}, check)
+}
+
+// RemoveMember removes a member from the organization, which only its owners can do, unless members leave on their own.
+// The organization must keep at least one owner.
+func (o *organizationService) RemoveMember(user_id string, id string, member_id string) error {
+	required := types.RoleOwner
+	if member_id == user_id {
+		required = types.RoleViewer
+	}
+	if _, err := memberRole(o.db, id, user_id, required); err != nil {
+		return err
+	}
+
+	err := o.db.DeleteOrganizationMember(id, member_id, keepOwner(member_id))
+	if err == sql.ErrNoRows {
+		return types.ErrNotFound
+	}
+	return err
+}
+
+// resolveUser makes sure the auth service knows the user
+func (o *organizationService) resolveUser(user_id string) error {
+	_, err := o.users.GetUserByID(user_id)
+	if err == sql.ErrNoRows {
+		return ErrUnknownUser
+	}
+	return err
+}
+
+// keepOwner checks that the organization has an owner besides the member, who is about to stop being one.
+// The owners are checked as the database locked them, in the transaction changing the member.
+func keepOwner(member_id string) func(owners []*types.OrganizationMember) error {
+	return func(owners []*types.OrganizationMember) error {
+		for _, owner := range owners {
+			if owner.UserID != member_id {
+				return nil
+			}
+		}
+		return ErrLastOwner
+	}
+}
+
+// memberRole returns the role of the user in the organization, if it is at least the required one.
+// The organization is not found when the user isn't a member of it.
+func memberRole(db repositories.Database, organization_id string, user_id string, required string) (string, error) {
+	member, err := db.GetOrganizationMember(organization_id, user_id)
+	if err == sql.ErrNoRows {
+		return "", types.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if roleRanks[member.Role] < roleRanks[required] {
+		return "", ErrInsufficientRole
+	}
+	return member.Role, nil
+}
+
+var ErrInvalidRole error = errors.New("The role must be owner, editor or viewer")
+var ErrInsufficientRole error = errors.New("Your role in the organization doesn't allow this")
+var ErrUnknownUser error = errors.New("No user has this ID")
+var ErrLastOwner error = errors.New("An organization must keep at least one owner")
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gistsapp/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// knownUsers stands for the auth service, which knows the users it holds
+type knownUsers map[string]bool
+
+func (k knownUsers) GetUserByID(id string) (*types.User, error) {
+	if !k[id] {
+		return nil, sql.ErrNoRows
+	}
+	return &types.User{ID: id}, nil
+}
+
+// newOrganizationTest sets up an organization with an owner, an editor and a viewer, named after their role
+func newOrganizationTest(t *testing.T) (OrganizationService, *memoryDatabase, string) {
+	db := newMemoryDatabase()
+	organizations := NewOrganizationService(db, knownUsers{"owner": true, "editor": true, "viewer": true, "newcomer": true})
+	organization, err := organizations.Create("owner", "organization")
+	require.NoError(t, err)
+	for _, role := range []string{types.RoleEditor, types.RoleViewer} {
+		_, err := organizations.SetMember("owner", organization.ID, role, role)
+		require.NoError(t, err)
+	}
+	return organizations, db, organization.ID
+}
+
+func TestMemberRole(t *testing.T) {
+	_, db, id := newOrganizationTest(t)
+
+	tests := []struct {
+		user     string
+		required string
+		err      error
+	}{
+		{user: "owner", required: types.RoleOwner},
+		{user: "owner", required: types.RoleEditor},
+		{user: "owner", required: types.RoleViewer},
+		{user: "editor", required: types.RoleOwner, err: ErrInsufficientRole},
+		{user: "editor", required: types.RoleEditor},
+		{user: "editor", required: types.RoleViewer},
+		{user: "viewer", required: types.RoleOwner, err: ErrInsufficientRole},
+		{user: "viewer", required: types.RoleEditor, err: ErrInsufficientRole},
+		{user: "viewer", required: types.RoleViewer},
+		{user: "stranger", required: types.RoleViewer, err: types.ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.user+" as "+test.required, func(t *testing.T) {
+			role, err := memberRole(db, id, test.user, test.required)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.user, role)
+		})
+	}
+}
+
+func TestKeepOwner(t *testing.T) {
+	owner := &types.OrganizationMember{UserID: "owner", Role: types.RoleOwner}
+	other := &types.OrganizationMember{UserID: "other", Role: types.RoleOwner}
+
+	tests := []struct {
+		name   string
+		owners []*types.OrganizationMember
+		member string
+		err    error
+	}{
+		{name: "last owner", owners: []*types.OrganizationMember{owner}, member: "owner", err: ErrLastOwner},
+		{name: "another owner", owners: []*types.OrganizationMember{owner, other}, member: "owner"},
+		{name: "not an owner", owners: []*types.OrganizationMember{owner}, member: "editor"},
+		{name: "no owner", owners: []*types.OrganizationMember{}, member: "editor", err: ErrLastOwner},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.err, keepOwner(test.member)(test.owners))
+		})
+	}
+}
+
+func TestSetMember(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		member string
+		role   string
+		err    error
+	}{
+		{name: "add a member", user: "owner", member: "newcomer", role: types.RoleViewer},
+		{name: "promote a member", user: "owner", member: "viewer", role: types.RoleOwner},
+		{name: "demote a member", user: "owner", member: "editor", role: types.RoleViewer},
+		{name: "unknown role", user: "owner", member: "viewer", role: "admin", err: ErrInvalidRole},
+		{name: "unknown user", user: "owner", member: "ghost", role: types.RoleViewer, err: ErrUnknownUser},
+		{name: "by an editor", user: "editor", member: "viewer", role: types.RoleEditor, err: ErrInsufficientRole},
+		{name: "by a stranger", user: "stranger", member: "viewer", role: types.RoleEditor, err: types.ErrNotFound},
+		{name: "demote the last owner", user: "owner", member: "owner", role: types.RoleEditor, err: ErrLastOwner},
+		{name: "owner stays owner", user: "owner", member: "owner", role: types.RoleOwner},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			organizations, db, id := newOrganizationTest(t)
+			member, err := organizations.SetMember(test.user, id, test.member, test.role)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.role, member.Role)
+			stored, err := db.GetOrganizationMember(id, test.member)
+			require.NoError(t, err)
+			assert.Equal(t, test.role, stored.Role)
+		})
+	}
+
+	t.Run("demote an owner once there is another", func(t *testing.T) {
+		organizations, _, id := newOrganizationTest(t)
+		_, err := organizations.SetMember("owner", id, "editor", types.RoleOwner)
+		require.NoError(t, err)
+		_, err = organizations.SetMember("editor", id, "owner", types.RoleViewer)
+		require.NoError(t, err)
+		_, err = organizations.SetMember("editor", id, "editor", types.RoleViewer)
+		assert.Equal(t, ErrLastOwner, err)
+	})
+}
+
+func TestRemoveMember(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		member string
+		err    error
+	}{
+		{name: "by an owner", user: "owner", member: "editor"},
+		{name: "leave", user: "viewer", member: "viewer"},
+		{name: "by an editor", user: "editor", member: "viewer", err: ErrInsufficientRole},
+		{name: "by a stranger", user: "stranger", member: "viewer", err: types.ErrNotFound},
+		{name: "stranger leaving", user: "stranger", member: "stranger", err: types.ErrNotFound},
+		{name: "not a member", user: "owner", member: "newcomer", err: types.ErrNotFound},
+		{name: "last owner leaving", user: "owner", member: "owner", err: ErrLastOwner},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			organizations, db, id := newOrganizationTest(t)
+			err := organizations.RemoveMember(test.user, id, test.member)
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			require.NoError(t, err)
+			_, err = db.GetOrganizationMember(id, test.member)
+			assert.Equal(t, sql.ErrNoRows, err)
+		})
+	}
+
+	t.Run("owner leaving another owner", func(t *testing.T) {
+		organizations, _, id := newOrganizationTest(t)
+		_, err := organizations.SetMember("owner", id, "editor", types.RoleOwner)
+		require.NoError(t, err)
+		require.NoError(t, organizations.RemoveMember("owner", id, "owner"))
+		_, err = organizations.Get("owner", id)
+		assert.Equal(t, types.ErrNotFound, err)
+		members, err := organizations.Members("editor", id)
+		require.NoError(t, err)
+		assert.Len(t, members, 2)
+	})
+}
@@ -0,0 +1,653 @@
+package http
+
+import (
+	"net/url"
+
+	"github.com/gistsapp/api/gists/config"
+	"github.com/gistsapp/api/gists/core"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// scopes a token must have been granted to read or write gists, when it is limited to some
+const (
+	ScopeGistsRead  = "gists:read"
+	ScopeGistsWrite = "gists:write"
+)
+
+type GistController interface {
+	Create() fiber.Handler
+	List() fiber.Handler
+	Get() fiber.Handler
+	Rename() fiber.Handler
+	Delete() fiber.Handler
+	AddFile() fiber.Handler
+	UpdateFile() fiber.Handler
+	RemoveFile() fiber.Handler
+	Revisions() fiber.Handler
+	Revision() fiber.Handler
+	Diff() fiber.Handler
+	Restore() fiber.Handler
+	Register(app *fiber.App)
+}
+
+type gistController struct {
+	service  core.GistService
+	verifier verifier.Verifier
+	config   *config.Config
+}
+
+func NewGistController(service core.GistService, verifier verifier.Verifier, config *config.Config) GistController {
+	return gistController{
+		service:  service,
+		verifier: verifier,
+		config:   config,
+	}
+}
+
+// Create godoc
+//
+//	@Summary		Create a gist
+//	@Description	Use this endpoint to create a gist owned by the authenticated user, or by one of their organizations, with at least one file
+//	@Tags			gists
+//	@Accept			json
+//	@Produce		json
+//	@Param			gist	body		http.GistValidator	true	"Name and files of the gist, and the organization owning it"
+//	@Success		201		{object}	types.Gist
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		413		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Create() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(GistValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		files := []*types.GistFile{}
+		for _, file := range e.Files {
+			files = append(files, &types.GistFile{
+				Name:    file.Name,
+				Content: file.Content,
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		gist, err := g.service.Create(user_id, e.OrganizationID, e.Name, files)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if status, ok := fileErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(gist)
+	}
+}
+
+// List godoc
+//
+//	@Summary		List gists
+//	@Description	Use this endpoint to list the personal gists of the authenticated user, the last updated first.
+//	@Description	The gists of their organizations are listed at /organizations/{id}/gists.
+//	@Tags			gists
+//	@Produce		json
+//	@Param			limit	query		int	false	"Number of gists, 20 by default and 100 at most"
+//	@Param			offset	query		int	false	"Number of gists to skip"
+//	@Success		200		{array}		types.Gist
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) List() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(PaginationValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		gists, err := g.service.List(user_id, e.Limit, e.Offset)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(gists)
+	}
+}
+
+// Get godoc
+//
+//	@Summary		Get a gist
+//	@Description	Use this endpoint to get one of the gists of the authenticated user or of their organizations
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id	path		string	true	"Gist ID"
+//	@Success		200	{object}	types.Gist
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id} [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Get() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		gist, err := g.service.Get(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(gist)
+	}
+}
+
+// Rename godoc
+//
+//	@Summary		Rename a gist
+//	@Description	Use this endpoint to rename one of the gists of the authenticated user or of their organizations
+//	@Tags			gists
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Gist ID"
+//	@Param			gist	body		http.GistRenameValidator	true	"New name of the gist"
+//	@Success		200		{object}	types.Gist
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id} [patch]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Rename() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(GistRenameValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		gist, err := g.service.Rename(user_id, c.Params("id"), e.Name)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(gist)
+	}
+}
+
+// Delete godoc
+//
+//	@Summary		Delete a gist
+//	@Description	Use this endpoint to delete one of the gists of the authenticated user or of their organizations
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id	path		string	true	"Gist ID"
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id} [delete]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Delete() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		err := g.service.Delete(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Gist deleted",
+		})
+	}
+}
+
+// AddFile godoc
+//
+//	@Summary		Add a file to a gist
+//	@Description	Use this endpoint to add a file to one of the gists of the authenticated user or of their organizations, its language being detected from its extension
+//	@Tags			gists
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string					true	"Gist ID"
+//	@Param			file	body		http.GistFileValidator	true	"Name and content of the file"
+//	@Success		201		{object}	types.GistFile
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		413		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/files [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) AddFile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(GistFileValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		file, err := g.service.AddFile(user_id, c.Params("id"), &types.GistFile{
+			Name:    e.Name,
+			Content: e.Content,
+		})
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		if status, ok := fileErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(file)
+	}
+}
+
+// UpdateFile godoc
+//
+//	@Summary		Update a file of a gist
+//	@Description	Use this endpoint to rename a file of one of the gists of the authenticated user or of their organizations, or to replace its content, the other files being left as they are
+//	@Tags			gists
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string						true	"Gist ID"
+//	@Param			name	path		string						true	"File name"
+//	@Param			file	body		http.GistFileUpdateValidator	true	"New name and content of the file, the fields left out being kept"
+//	@Success		200		{object}	types.GistFile
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		413		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/files/{name} [patch]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) UpdateFile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(GistFileUpdateValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		name, err := url.PathUnescape(c.Params("name"))
+		if err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		file, err := g.service.UpdateFile(user_id, c.Params("id"), name, e.Name, e.Content)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "File not found",
+			})
+		}
+		if status, ok := fileErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(file)
+	}
+}
+
+// RemoveFile godoc
+//
+//	@Summary		Remove a file from a gist
+//	@Description	Use this endpoint to remove a file from one of the gists of the authenticated user or of their organizations, which must keep at least one
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id		path		string	true	"Gist ID"
+//	@Param			name	path		string	true	"File name"
+//	@Success		200		{object}	http.HTTPMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/files/{name} [delete]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) RemoveFile() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("name"))
+		if err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		err = g.service.RemoveFile(user_id, c.Params("id"), name)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "File not found",
+			})
+		}
+		if err == core.ErrLastFile {
+			return c.Status(fiber.StatusConflict).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "File removed",
+		})
+	}
+}
+
+// Revisions godoc
+//
+//	@Summary		List the revisions of a gist
+//	@Description	Use this endpoint to list the revisions of one of the gists of the authenticated user or of their organizations, the latest first, without their files.
+//	@Description	Every edit of a gist makes a new revision.
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id		path		string	true	"Gist ID"
+//	@Param			limit	query		int		false	"Number of revisions, 20 by default and 100 at most"
+//	@Param			offset	query		int		false	"Number of revisions to skip"
+//	@Success		200		{array}		types.GistRevision
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/revisions [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Revisions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(PaginationValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		revisions, err := g.service.Revisions(user_id, c.Params("id"), e.Limit, e.Offset)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(revisions)
+	}
+}
+
+// Revision godoc
+//
+//	@Summary		Get a gist at a revision
+//	@Description	Use this endpoint to get one of the gists of the authenticated user or of their organizations as it was at one of its revisions
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id			path		string	true	"Gist ID"
+//	@Param			number		path		int		true	"Revision number"
+//	@Success		200			{object}	types.GistRevision
+//	@Failure		401			{object}	http.HTTPErrorMessage
+//	@Failure		404			{object}	http.HTTPErrorMessage
+//	@Failure		500			{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/revisions/{number} [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Revision() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("number")
+		if err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Revision not found",
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		revision, err := g.service.Revision(user_id, c.Params("id"), number)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Revision not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(revision)
+	}
+}
+
+// Diff godoc
+//
+//	@Summary		Compare two revisions of a gist
+//	@Description	Use this endpoint to get the unified diff of the files of one of the gists of the authenticated user or of their organizations, from a revision to another
+//	@Tags			gists
+//	@Produce		plain
+//	@Param			id		path		string	true	"Gist ID"
+//	@Param			from	query		int		true	"Revision number to compare from"
+//	@Param			to		query		int		false	"Revision number to compare to, the latest by default"
+//	@Success		200		{string}	string	"unified diff"
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		422		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/diff [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Diff() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(DiffValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		diff, err := g.service.Diff(user_id, c.Params("id"), e.From, e.To)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Revision not found",
+			})
+		}
+		if err == core.ErrDiffTooLarge {
+			return c.Status(fiber.ErrUnprocessableEntity.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		c.Set(fiber.HeaderContentType, "text/x-diff; charset=utf-8")
+		return c.SendString(diff)
+	}
+}
+
+// Restore godoc
+//
+//	@Summary		Restore a revision of a gist
+//	@Description	Use this endpoint to put one of the gists of the authenticated user or of their organizations back as it was at one of its revisions, which makes a new revision
+//	@Tags			gists
+//	@Produce		json
+//	@Param			id		path		string	true	"Gist ID"
+//	@Param			number	path		int		true	"Revision number"
+//	@Success		200		{object}	types.Gist
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		413		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/gists/{id}/revisions/{number}/restore [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (g gistController) Restore() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		number, err := c.ParamsInt("number")
+		if err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Revision not found",
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		gist, err := g.service.Restore(user_id, c.Params("id"), number)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Revision not found",
+			})
+		}
+		if status, ok := fileErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(gist)
+	}
+}
+
+// fileErrorStatus gives the status of the errors about files that don't fit in a gist
+func fileErrorStatus(err error) (int, bool) {
+	switch err {
+	case core.ErrNoFiles, core.ErrInvalidFileName, core.ErrTooManyFiles:
+		return fiber.StatusBadRequest, true
+	case core.ErrFileExists:
+		return fiber.StatusConflict, true
+	case core.ErrFileTooLarge, core.ErrGistTooLarge:
+		return fiber.StatusRequestEntityTooLarge, true
+	}
+	return 0, false
+}
+
+// gistID answers 404 for the ids that can't be a gist's, before they reach the database
+func gistID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := uuid.Parse(c.Params("id")); err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Gist not found",
+			})
+		}
+		return c.Next()
+	}
+}
+
+func (g gistController) Register(app *fiber.App) {
+	gists := app.Group("/gists", verifier.Middleware(g.verifier, g.config.Cookies.Auth.Name()))
+	gists.Post("/", verifier.RequireScopes(ScopeGistsWrite), g.Create())
+	gists.Get("/", verifier.RequireScopes(ScopeGistsRead), g.List())
+	gists.Get("/:id", gistID(), verifier.RequireScopes(ScopeGistsRead), g.Get())
+	gists.Patch("/:id", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.Rename())
+	gists.Delete("/:id", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.Delete())
+	gists.Post("/:id/files", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.AddFile())
+	gists.Patch("/:id/files/:name", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.UpdateFile())
+	gists.Delete("/:id/files/:name", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.RemoveFile())
+	gists.Get("/:id/revisions", gistID(), verifier.RequireScopes(ScopeGistsRead), g.Revisions())
+	gists.Get("/:id/revisions/:number", gistID(), verifier.RequireScopes(ScopeGistsRead), g.Revision())
+	gists.Post("/:id/revisions/:number/restore", gistID(), verifier.RequireScopes(ScopeGistsWrite), g.Restore())
+	gists.Get("/:id/diff", gistID(), verifier.RequireScopes(ScopeGistsRead), g.Diff())
+}
@@ -0,0 +1,17 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+type Handler interface {
+	Register(app *fiber.App)
+}
+
+type HTTPErrorMessage struct {
+	Error string `json:"error"`
+}
+
+type HTTPMessage struct {
+	Message string `json:"message"`
+}
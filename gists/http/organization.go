@@ -0,0 +1,376 @@
+package http
+
+import (
+	"github.com/gistsapp/api/gists/config"
+	"github.com/gistsapp/api/gists/core"
+	"github.com/gistsapp/api/types"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type OrganizationController interface {
+	Create() fiber.Handler
+	List() fiber.Handler
+	Get() fiber.Handler
+	Delete() fiber.Handler
+	Members() fiber.Handler
+	SetMember() fiber.Handler
+	RemoveMember() fiber.Handler
+	Gists() fiber.Handler
+	Register(app *fiber.App)
+}
+
+type organizationController struct {
+	service      core.OrganizationService
+	gist_service core.GistService
+	verifier     verifier.Verifier
+	config       *config.Config
+}
+
+func NewOrganizationController(service core.OrganizationService, gist_service core.GistService, verifier verifier.Verifier, config *config.Config) OrganizationController {
+	return organizationController{
+		service:      service,
+		gist_service: gist_service,
+		verifier:     verifier,
+		config:       config,
+	}
+}
+
+// Create godoc
+//
+//	@Summary		Create an organization
+//	@Description	Use this endpoint to create an organization, the authenticated user being its owner
+//	@Tags			organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			organization	body		http.OrganizationValidator	true	"Name of the organization"
+//	@Success		201				{object}	types.Organization
+//	@Failure		400				{object}	http.HTTPErrorMessage
+//	@Failure		401				{object}	http.HTTPErrorMessage
+//	@Failure		500				{object}	http.HTTPErrorMessage
+//	@Router			/organizations [post]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) Create() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(OrganizationValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		organization, err := o.service.Create(user_id, e.Name)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusCreated).JSON(organization)
+	}
+}
+
+// List godoc
+//
+//	@Summary		List organizations
+//	@Description	Use this endpoint to list the organizations the authenticated user is a member of, with their role
+//	@Tags			organizations
+//	@Produce		json
+//	@Success		200	{array}		types.Organization
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/organizations [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) List() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		organizations, err := o.service.List(user_id)
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(organizations)
+	}
+}
+
+// Get godoc
+//
+//	@Summary		Get an organization
+//	@Description	Use this endpoint to get one of the organizations of the authenticated user, with their role
+//	@Tags			organizations
+//	@Produce		json
+//	@Param			id	path		string	true	"Organization ID"
+//	@Success		200	{object}	types.Organization
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id} [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) Get() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		organization, err := o.service.Get(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(organization)
+	}
+}
+
+// Delete godoc
+//
+//	@Summary		Delete an organization
+//	@Description	Use this endpoint to delete one of the organizations the authenticated user owns, along with its gists
+//	@Tags			organizations
+//	@Produce		json
+//	@Param			id	path		string	true	"Organization ID"
+//	@Success		200	{object}	http.HTTPMessage
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		403	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id} [delete]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) Delete() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		err := o.service.Delete(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		if err == core.ErrInsufficientRole {
+			return c.Status(fiber.StatusForbidden).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Organization deleted",
+		})
+	}
+}
+
+// Members godoc
+//
+//	@Summary		List the members of an organization
+//	@Description	Use this endpoint to list the members of one of the organizations of the authenticated user, with their role
+//	@Tags			organizations
+//	@Produce		json
+//	@Param			id	path		string	true	"Organization ID"
+//	@Success		200	{array}		types.OrganizationMember
+//	@Failure		401	{object}	http.HTTPErrorMessage
+//	@Failure		404	{object}	http.HTTPErrorMessage
+//	@Failure		500	{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id}/members [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) Members() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user_id := c.Locals("userID").(string)
+		members, err := o.service.Members(user_id, c.Params("id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(members)
+	}
+}
+
+// SetMember godoc
+//
+//	@Summary		Add a member to an organization
+//	@Description	Use this endpoint to add a user to one of the organizations the authenticated user owns, or to change the role of a member.
+//	@Description	Unknown users are not found, and an organization keeps at least one owner.
+//	@Tags			organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string							true	"Organization ID"
+//	@Param			user_id	path		string							true	"User ID"
+//	@Param			member	body		http.OrganizationMemberValidator	true	"Role of the member"
+//	@Success		200		{object}	types.OrganizationMember
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id}/members/{user_id} [put]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) SetMember() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(OrganizationMemberValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if _, err := uuid.Parse(c.Params("user_id")); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: "Invalid user ID",
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		member, err := o.service.SetMember(user_id, c.Params("id"), c.Params("user_id"), e.Role)
+		if status, ok := memberErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(member)
+	}
+}
+
+// RemoveMember godoc
+//
+//	@Summary		Remove a member from an organization
+//	@Description	Use this endpoint to remove a member from one of the organizations the authenticated user owns, or to leave an organization.
+//	@Description	An organization keeps at least one owner.
+//	@Tags			organizations
+//	@Produce		json
+//	@Param			id		path		string	true	"Organization ID"
+//	@Param			user_id	path		string	true	"User ID"
+//	@Success		200		{object}	http.HTTPMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		403		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		409		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id}/members/{user_id} [delete]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) RemoveMember() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// no such member can exist, and the database would reject the ID
+		if _, err := uuid.Parse(c.Params("user_id")); err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Member not found",
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		err := o.service.RemoveMember(user_id, c.Params("id"), c.Params("user_id"))
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Member not found",
+			})
+		}
+		if status, ok := memberErrorStatus(err); ok {
+			return c.Status(status).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(HTTPMessage{
+			Message: "Member removed",
+		})
+	}
+}
+
+// Gists godoc
+//
+//	@Summary		List the gists of an organization
+//	@Description	Use this endpoint to list the gists of one of the organizations of the authenticated user, the last updated first
+//	@Tags			organizations
+//	@Produce		json
+//	@Param			id		path		string	true	"Organization ID"
+//	@Param			limit	query		int		false	"Number of gists, 20 by default and 100 at most"
+//	@Param			offset	query		int		false	"Number of gists to skip"
+//	@Success		200		{array}		types.Gist
+//	@Failure		400		{object}	http.HTTPErrorMessage
+//	@Failure		401		{object}	http.HTTPErrorMessage
+//	@Failure		404		{object}	http.HTTPErrorMessage
+//	@Failure		500		{object}	http.HTTPErrorMessage
+//	@Router			/organizations/{id}/gists [get]
+//	@Param			Authorization	header	string	true	"Authorization"
+func (o organizationController) Gists() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e := new(PaginationValidator)
+		if err := e.Validate(c); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+
+		user_id := c.Locals("userID").(string)
+		gists, err := o.gist_service.ListOrganization(user_id, c.Params("id"), e.Limit, e.Offset)
+		if err == types.ErrNotFound {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(HTTPErrorMessage{
+				Error: err.Error(),
+			})
+		}
+		return c.JSON(gists)
+	}
+}
+
+// memberErrorStatus gives the status of the errors about the members of an organization
+func memberErrorStatus(err error) (int, bool) {
+	switch err {
+	case types.ErrNotFound, core.ErrUnknownUser:
+		return fiber.StatusNotFound, true
+	case core.ErrInvalidRole:
+		return fiber.StatusBadRequest, true
+	case core.ErrInsufficientRole:
+		return fiber.StatusForbidden, true
+	case core.ErrLastOwner:
+		return fiber.StatusConflict, true
+	}
+	return 0, false
+}
+
+// organizationID answers 404 for the ids that can't be an organization's, before they reach the database
+func organizationID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := uuid.Parse(c.Params("id")); err != nil {
+			return c.Status(fiber.ErrNotFound.Code).JSON(HTTPErrorMessage{
+				Error: "Organization not found",
+			})
+		}
+		return c.Next()
+	}
+}
+
+func (o organizationController) Register(app *fiber.App) {
+	organizations := app.Group("/organizations", verifier.Middleware(o.verifier, o.config.Cookies.Auth.Name()))
+	organizations.Post("/", verifier.RequireScopes(ScopeGistsWrite), o.Create())
+	organizations.Get("/", verifier.RequireScopes(ScopeGistsRead), o.List())
+	organizations.Get("/:id", organizationID(), verifier.RequireScopes(ScopeGistsRead), o.Get())
+	organizations.Delete("/:id", organizationID(), verifier.RequireScopes(ScopeGistsWrite), o.Delete())
+	organizations.Get("/:id/members", organizationID(), verifier.RequireScopes(ScopeGistsRead), o.Members())
+	organizations.Put("/:id/members/:user_id", organizationID(), verifier.RequireScopes(ScopeGistsWrite), o.SetMember())
+	organizations.Delete("/:id/members/:user_id", organizationID(), verifier.RequireScopes(ScopeGistsWrite), o.RemoveMember())
+	organizations.Get("/:id/gists", organizationID(), verifier.RequireScopes(ScopeGistsRead), o.Gists())
+}
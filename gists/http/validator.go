@@ -0,0 +1,173 @@
+package http
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+type Validator interface {
+	Validate(c *fiber.Ctx) error
+}
+
+type BaseValidator struct{}
+
+// GistFileValidator is a file of a gist
+type GistFileValidator struct {
+	BaseValidator
+	Name    string `json:"name" validate:"required,max=255"`
+	Content string `json:"content"`
+}
+
+func (g *GistFileValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(g); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(g); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GistValidator creates a gist
+type GistValidator struct {
+	BaseValidator
+	Name           string              `json:"name" validate:"required,max=255"`
+	Files          []GistFileValidator `json:"files" validate:"required,min=1,dive"`
+	OrganizationID *string             `json:"organization_id" validate:"omitnil,uuid"` // a personal gist when left out
+}
+
+func (g *GistValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(g); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(g); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GistRenameValidator renames a gist
+type GistRenameValidator struct {
+	BaseValidator
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+func (g *GistRenameValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(g); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(g); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GistFileUpdateValidator renames a file of a gist or replaces its content, the fields left out being kept
+type GistFileUpdateValidator struct {
+	BaseValidator
+	Name    *string `json:"name" validate:"omitnil,min=1,max=255"`
+	Content *string `json:"content"`
+}
+
+func (g *GistFileUpdateValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(g); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(g); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PaginationValidator reads the page of a list from the query
+type PaginationValidator struct {
+	BaseValidator
+	Limit  int `query:"limit" validate:"min=0,max=100"`
+	Offset int `query:"offset" validate:"min=0"`
+}
+
+func (p *PaginationValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.QueryParser(p); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+
+	if p.Limit == 0 {
+		p.Limit = 20
+	}
+	return nil
+}
+
+// DiffValidator reads the revisions to compare from the query
+type DiffValidator struct {
+	BaseValidator
+	From int `query:"from" validate:"required,min=1"`
+	To   int `query:"to" validate:"min=0"` // the latest revision by default
+}
+
+func (d *DiffValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.QueryParser(d); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(d); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OrganizationValidator creates an organization
+type OrganizationValidator struct {
+	BaseValidator
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+func (o *OrganizationValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(o); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(o); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OrganizationMemberValidator gives a role to a member of an organization
+type OrganizationMemberValidator struct {
+	BaseValidator
+	Role string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+func (o *OrganizationMemberValidator) Validate(c *fiber.Ctx) error {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := c.BodyParser(o); err != nil {
+		return err
+	}
+
+	if err := validate.Struct(o); err != nil {
+		return err
+	}
+
+	return nil
+}
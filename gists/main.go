@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	stdhttp "net/http"
+	"os"
+	"time"
+
+	"github.com/gistsapp/api/gists/config"
+	"github.com/gistsapp/api/gists/core"
+	"github.com/gistsapp/api/gists/http"
+	"github.com/gistsapp/api/gists/repositories"
+	"github.com/gistsapp/api/types/verifier"
+	"github.com/gofiber/fiber/v2/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authTimeout bounds every call to the auth service
+const authTimeout = 10 * time.Second
+
+// @title			Gists API
+// @version		0.1
+// @description	This is the API for the Gists service
+// @contact.name	Courtcircuits
+// @contact.url	https://github.com/courtcircuits
+// @contact.email	tristan-mihai.radulescu@etu.umontpellier.fr
+func main() {
+	config.LoadConfig() // reads the config file
+	conf := config.GetConfig()
+	log.Info("Starting Gists service")
+	db, err := repositories.NewPgDatabase(conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Database)
+	if err != nil {
+		panic(err)
+	}
+
+	if os.Getenv("BOOTSTRAP") == "true" {
+		err := db.Bootstrap()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	gist_service := core.NewGistService(db, conf.Gists)
+	if conf.Auth.ClientID == "" {
+		panic("auth.client_id is required to introspect the tokens and resolve the users")
+	}
+	// the token requests go through the client of the context, the calls to the auth service through the client it returns:
+	// neither may hang the requests of our users when the auth service doesn't answer
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &stdhttp.Client{Timeout: authTimeout})
+	client := (&clientcredentials.Config{
+		ClientID:     conf.Auth.ClientID,
+		ClientSecret: conf.Auth.ClientSecret,
+		TokenURL:     conf.Auth.TokenURL,
+		Scopes:       []string{"tokens:introspect", "users:read"},
+	}).Client(ctx)
+	client.Timeout = authTimeout
+	introspection := verifier.NewIntrospectionVerifier(conf.Auth.IntrospectionURL, client)
+	organization_service := core.NewOrganizationService(db, repositories.NewAuthUsers(conf.Auth.UsersURL, client))
+	// the keys reject forged and expired tokens before the auth service is asked whether they were revoked
+	access_tokens := verifier.WithIntrospection(verifier.New(verifier.NewRemoteKeySource(conf.Auth.JWKSURL), conf.Auth.Issuer, conf.Auth.Audience), introspection)
+	token_verifier := verifier.WithPersonalAccessTokens(access_tokens, introspection)
+
+	gist_handler := http.NewGistController(gist_service, token_verifier, &conf)
+	organization_handler := http.NewOrganizationController(organization_service, gist_service, token_verifier, &conf)
+
+	server := http.NewServer(conf.Port)
+	server.Setup(gist_handler, organization_handler)
+	server.Ignite()
+}
@@ -0,0 +1,12 @@
+ALTER TABLE gist ADD COLUMN content TEXT NOT NULL DEFAULT '';
+
+-- the files of a gist are put back together, one after the other
+UPDATE gist SET content = files.content
+FROM (
+  SELECT gist_id, string_agg(content, E'\n' ORDER BY created_at, name) AS content FROM gist_file GROUP BY gist_id
+) AS files
+WHERE gist.gist_id = files.gist_id;
+
+ALTER TABLE gist ALTER COLUMN content DROP DEFAULT;
+
+DROP TABLE IF EXISTS gist_file;
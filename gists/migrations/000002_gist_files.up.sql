@@ -0,0 +1,19 @@
+-- the content of a gist is split into named files
+CREATE TABLE IF NOT EXISTS gist_file(
+  gist_file_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  gist_id uuid NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  content TEXT NOT NULL,
+  size INTEGER GENERATED ALWAYS AS (octet_length(content)) STORED,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE gist_file ADD CONSTRAINT gist_file_gist_id_name_key UNIQUE (gist_id, name);
+ALTER TABLE gist_file ADD CONSTRAINT gist_file_gist_id_fkey FOREIGN KEY (gist_id) REFERENCES gist(gist_id) ON DELETE CASCADE;
+
+-- the existing gists keep their content as a single file, named after the gist
+INSERT INTO gist_file (gist_id, name, content, created_at, updated_at)
+SELECT gist_id, name, content, created_at, updated_at FROM gist;
+
+ALTER TABLE gist DROP COLUMN content;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS gist_revision_file;
+DROP TABLE IF EXISTS gist_revision;
+ALTER TABLE gist DROP COLUMN IF EXISTS revision;
@@ -0,0 +1,33 @@
+-- every edit of a gist is kept as a revision, holding a copy of the gist as it was after the edit
+ALTER TABLE gist ADD COLUMN revision INTEGER NOT NULL DEFAULT 0; -- number of the latest revision
+
+CREATE TABLE IF NOT EXISTS gist_revision(
+  revision_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  gist_id uuid NOT NULL,
+  number INTEGER NOT NULL,
+  user_id uuid NOT NULL, -- who made the edit
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE gist_revision ADD CONSTRAINT gist_revision_gist_id_number_key UNIQUE (gist_id, number);
+ALTER TABLE gist_revision ADD CONSTRAINT gist_revision_gist_id_fkey FOREIGN KEY (gist_id) REFERENCES gist(gist_id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS gist_revision_file(
+  revision_id uuid NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  content TEXT NOT NULL,
+  size INTEGER GENERATED ALWAYS AS (octet_length(content)) STORED,
+  PRIMARY KEY (revision_id, name)
+);
+
+ALTER TABLE gist_revision_file ADD CONSTRAINT gist_revision_file_revision_id_fkey FOREIGN KEY (revision_id) REFERENCES gist_revision(revision_id) ON DELETE CASCADE;
+
+-- the existing gists start their history as they are now
+INSERT INTO gist_revision (gist_id, number, user_id, name, created_at)
+SELECT gist_id, 1, user_id, name, updated_at FROM gist;
+
+INSERT INTO gist_revision_file (revision_id, name, content)
+SELECT gist_revision.revision_id, gist_file.name, gist_file.content FROM gist_revision JOIN gist_file ON gist_file.gist_id = gist_revision.gist_id;
+
+UPDATE gist SET revision = 1;
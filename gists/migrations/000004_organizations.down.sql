@@ -0,0 +1,4 @@
+DELETE FROM gist WHERE organization_id IS NOT NULL;
+ALTER TABLE gist DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organization_member;
+DROP TABLE IF EXISTS organization;
@@ -0,0 +1,25 @@
+-- organizations own gists shared by their members, whose role tells what they can do with them
+CREATE TABLE IF NOT EXISTS organization(
+  organization_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  name VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS organization_member(
+  organization_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  role VARCHAR(16) NOT NULL, -- owner, editor or viewer
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (organization_id, user_id)
+);
+
+ALTER TABLE organization_member ADD CONSTRAINT organization_member_role_check CHECK (role IN ('owner', 'editor', 'viewer'));
+ALTER TABLE organization_member ADD CONSTRAINT organization_member_organization_id_fkey FOREIGN KEY (organization_id) REFERENCES organization(organization_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS organization_member_user_id_idx ON organization_member(user_id);
+
+-- the gists of an organization are deleted along with it, user_id being the member who created them
+ALTER TABLE gist ADD COLUMN organization_id uuid;
+ALTER TABLE gist ADD CONSTRAINT gist_organization_id_fkey FOREIGN KEY (organization_id) REFERENCES organization(organization_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS gist_organization_id_idx ON gist(organization_id, updated_at DESC);
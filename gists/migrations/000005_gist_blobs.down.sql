@@ -0,0 +1,6 @@
+ALTER TABLE gist_revision_file ADD COLUMN IF NOT EXISTS content TEXT;
+UPDATE gist_revision_file SET content = gist_blob.content FROM gist_blob WHERE gist_blob.blob_id = gist_revision_file.blob_id;
+ALTER TABLE gist_revision_file ALTER COLUMN content SET NOT NULL;
+ALTER TABLE gist_revision_file ADD COLUMN IF NOT EXISTS size INTEGER GENERATED ALWAYS AS (octet_length(content)) STORED;
+ALTER TABLE gist_revision_file DROP COLUMN IF EXISTS blob_id;
+DROP TABLE IF EXISTS gist_blob;
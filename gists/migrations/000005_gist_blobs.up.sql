@@ -0,0 +1,30 @@
+-- the contents of the files are stored once per gist, the revisions referring to them,
+-- so that an edit only stores the contents it changes
+CREATE TABLE IF NOT EXISTS gist_blob(
+  blob_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+  gist_id uuid NOT NULL,
+  digest BYTEA NOT NULL, -- sha256 of the content
+  content TEXT NOT NULL,
+  size INTEGER GENERATED ALWAYS AS (octet_length(content)) STORED
+);
+
+ALTER TABLE gist_blob ADD CONSTRAINT gist_blob_gist_id_digest_key UNIQUE (gist_id, digest);
+ALTER TABLE gist_blob ADD CONSTRAINT gist_blob_gist_id_fkey FOREIGN KEY (gist_id) REFERENCES gist(gist_id) ON DELETE CASCADE;
+
+INSERT INTO gist_blob (gist_id, digest, content)
+SELECT DISTINCT gist_revision.gist_id, sha256(convert_to(gist_revision_file.content, 'UTF8')), gist_revision_file.content
+FROM gist_revision_file JOIN gist_revision ON gist_revision.revision_id = gist_revision_file.revision_id
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE gist_revision_file ADD COLUMN blob_id uuid;
+
+UPDATE gist_revision_file SET blob_id = gist_blob.blob_id
+FROM gist_revision, gist_blob
+WHERE gist_revision.revision_id = gist_revision_file.revision_id
+  AND gist_blob.gist_id = gist_revision.gist_id
+  AND gist_blob.digest = sha256(convert_to(gist_revision_file.content, 'UTF8'));
+
+ALTER TABLE gist_revision_file ALTER COLUMN blob_id SET NOT NULL;
+ALTER TABLE gist_revision_file ADD CONSTRAINT gist_revision_file_blob_id_fkey FOREIGN KEY (blob_id) REFERENCES gist_blob(blob_id) ON DELETE CASCADE;
+ALTER TABLE gist_revision_file DROP COLUMN size;
+ALTER TABLE gist_revision_file DROP COLUMN content;
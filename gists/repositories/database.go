@@ -0,0 +1,576 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gistsapp/api/types"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// Abstraction for database related operations
+type Database interface {
+	Bootstrap() error
+	CreateGist(gist *types.Gist) (*types.Gist, error)
+	GetGistByID(id string) (*types.Gist, error)
+	GetGistsByUserID(user_id string, limit int, offset int) ([]*types.Gist, error)
+	UpdateGist(gist *types.Gist, author_id string) (*types.Gist, error)
+	DeleteGist(id string) error
+	CreateGistFile(file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error)
+	UpdateGistFile(name string, file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error)
+	DeleteGistFile(gist_id string, name string, author_id string, check func(files []*types.GistFile) error) error
+	RestoreGistRevision(revision *types.GistRevision, author_id string) (*types.Gist, error)
+	GetGistRevisions(gist_id string, limit int, offset int) ([]*types.GistRevision, error)
+	GetGistRevision(gist_id string, number int) (*types.GistRevision, error)
+	GetGistsByOrganizationID(organization_id string, limit int, offset int) ([]*types.Gist, error)
+	CreateOrganization(organization *types.Organization, owner_id string) (*types.Organization, error)
+	GetOrganizationByID(id string) (*types.Organization, error)
+	GetOrganizationsByUserID(user_id string) ([]*types.Organization, error)
+	DeleteOrganization(id string) error
+	GetOrganizationMember(organization_id string, user_id string) (*types.OrganizationMember, error)
+	GetOrganizationMembers(organization_id string) ([]*types.OrganizationMember, error)
+	SetOrganizationMember(member *types.OrganizationMember, check func(owners []*types.OrganizationMember) error) (*types.OrganizationMember, error)
+	DeleteOrganizationMember(organization_id string, user_id string, check func(owners []*types.OrganizationMember) error) error
+}
+
+type PgDatabase struct {
+	db       *sqlx.DB
+	username string
+	password string
+	host     string
+	port     int
+	dbname   string
+}
+
+func NewPgDatabase(username string, password string, host string, port int, dbname string) (*PgDatabase, error) {
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable", host, port, username, dbname, password))
+	if err != nil {
+		return nil, err
+	}
+	database := PgDatabase{
+		db:       db,
+		username: username,
+		password: password,
+		host:     host,
+		port:     port,
+		dbname:   dbname,
+	}
+
+	return &database, nil
+}
+
+// Bootstrap applies the migrations found next to the executable
+func (db *PgDatabase) Bootstrap() error {
+	ex, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	migrationsPath := filepath.Join(filepath.Dir(ex), "migrations")
+
+	m, err := migrate.New(fmt.Sprintf("file://%s", migrationsPath), fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable", db.username, db.password, db.host, db.port, db.dbname))
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if err == migrate.ErrNoChange {
+		return nil
+	}
+	return err
+}
+
+// CreateGist creates the gist along with its files, as its first revision
+func (db *PgDatabase) CreateGist(gist *types.Gist) (*types.Gist, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var created_gist types.Gist
+	err = tx.Get(&created_gist, "INSERT INTO gist (user_id, organization_id, name) VALUES ($1, $2, $3) RETURNING *", gist.UserID, gist.OrganizationID, gist.Name)
+	if err != nil {
+		return nil, err
+	}
+	created_gist.Files = []*types.GistFile{}
+	for _, file := range gist.Files {
+		var created_file types.GistFile
+		err = tx.Get(&created_file, "INSERT INTO gist_file (gist_id, name, content) VALUES ($1, $2, $3) RETURNING *", created_gist.ID, file.Name, file.Content)
+		if err != nil {
+			return nil, duplicate(err)
+		}
+		created_gist.Files = append(created_gist.Files, &created_file)
+	}
+	if err := touchGist(tx, &created_gist, gist.UserID); err != nil {
+		return nil, err
+	}
+	return &created_gist, tx.Commit()
+}
+
+// GetGistByID returns the gist along with its files
+func (db *PgDatabase) GetGistByID(id string) (*types.Gist, error) {
+	var gist types.Gist
+	err := db.db.Get(&gist, "SELECT * FROM gist WHERE gist_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.getGistFiles([]*types.Gist{&gist}); err != nil {
+		return nil, err
+	}
+	return &gist, nil
+}
+
+// GetGistsByUserID lists the gists of the user along with their files, the last updated first, leaving out those of organizations
+func (db *PgDatabase) GetGistsByUserID(user_id string, limit int, offset int) ([]*types.Gist, error) {
+	gists := []*types.Gist{}
+	err := db.db.Select(&gists, "SELECT * FROM gist WHERE user_id = $1 AND organization_id IS NULL ORDER BY updated_at DESC, gist_id LIMIT $2 OFFSET $3", user_id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.getGistFiles(gists); err != nil {
+		return nil, err
+	}
+	return gists, nil
+}
+
+// GetGistsByOrganizationID lists the gists of the organization along with their files, the last updated first
+func (db *PgDatabase) GetGistsByOrganizationID(organization_id string, limit int, offset int) ([]*types.Gist, error) {
+	gists := []*types.Gist{}
+	err := db.db.Select(&gists, "SELECT * FROM gist WHERE organization_id = $1 ORDER BY updated_at DESC, gist_id LIMIT $2 OFFSET $3", organization_id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.getGistFiles(gists); err != nil {
+		return nil, err
+	}
+	return gists, nil
+}
+
+// getGistFiles fills the files of the gists, sorted by name
+func (db *PgDatabase) getGistFiles(gists []*types.Gist) error {
+	ids := []string{}
+	by_id := map[string]*types.Gist{}
+	for _, gist := range gists {
+		gist.Files = []*types.GistFile{}
+		ids = append(ids, gist.ID)
+		by_id[gist.ID] = gist
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	files := []*types.GistFile{}
+	err := db.db.Select(&files, "SELECT * FROM gist_file WHERE gist_id = ANY($1::uuid[]) ORDER BY name", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		by_id[file.GistID].Files = append(by_id[file.GistID].Files, file)
+	}
+	return nil
+}
+
+// UpdateGist renames the gist, returning it without its files. It returns sql.ErrNoRows if the gist doesn't exist.
+func (db *PgDatabase) UpdateGist(gist *types.Gist, author_id string) (*types.Gist, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var updated_gist types.Gist
+	err = tx.Get(&updated_gist, "UPDATE gist SET name = $1 WHERE gist_id = $2 RETURNING *", gist.Name, gist.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := touchGist(tx, &updated_gist, author_id); err != nil {
+		return nil, err
+	}
+	return &updated_gist, tx.Commit()
+}
+
+// DeleteGist deletes the gist along with its revisions. It returns sql.ErrNoRows if the gist doesn't exist.
+func (db *PgDatabase) DeleteGist(id string) error {
+	result, err := db.db.Exec("DELETE FROM gist WHERE gist_id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateGistFile adds the file to its gist, returning ErrDuplicateName if the gist already has a file with this name,
+// and sql.ErrNoRows if the gist doesn't exist.
+// check is given the files the gist would have, read once the gist is locked, and aborts the change with its error.
+func (db *PgDatabase) CreateGistFile(file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	files, err := lockGistFiles(tx, file.GistID)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(append(files, file)); err != nil {
+		return nil, err
+	}
+
+	var created_file types.GistFile
+	err = tx.Get(&created_file, "INSERT INTO gist_file (gist_id, name, content) VALUES ($1, $2, $3) RETURNING *", file.GistID, file.Name, file.Content)
+	if err != nil {
+		return nil, duplicate(err)
+	}
+	if err := createRevision(tx, file.GistID, author_id); err != nil {
+		return nil, err
+	}
+	return &created_file, tx.Commit()
+}
+
+// UpdateGistFile renames the file called name and replaces its content.
+// It returns sql.ErrNoRows if the gist has no such file, and ErrDuplicateName if another file of the gist already has the new name.
+// check is given the files the gist would have, read once the gist is locked, and aborts the change with its error.
+func (db *PgDatabase) UpdateGistFile(name string, file *types.GistFile, author_id string, check func(files []*types.GistFile) error) (*types.GistFile, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	files, err := lockGistFiles(tx, file.GistID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for i, existing := range files {
+		if existing.Name == name {
+			files[i] = file
+			found = true
+		}
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	if err := check(files); err != nil {
+		return nil, err
+	}
+
+	var updated_file types.GistFile
+	err = tx.Get(&updated_file, "UPDATE gist_file SET name = $1, content = $2, updated_at = NOW() WHERE gist_id = $3 AND name = $4 RETURNING *", file.Name, file.Content, file.GistID, name)
+	if err != nil {
+		return nil, duplicate(err)
+	}
+	if err := createRevision(tx, file.GistID, author_id); err != nil {
+		return nil, err
+	}
+	return &updated_file, tx.Commit()
+}
+
+// DeleteGistFile returns sql.ErrNoRows if the gist has no such file.
+// check is given the files the gist would have, read once the gist is locked, and aborts the change with its error.
+func (db *PgDatabase) DeleteGistFile(gist_id string, name string, author_id string, check func(files []*types.GistFile) error) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	files, err := lockGistFiles(tx, gist_id)
+	if err != nil {
+		return err
+	}
+	remaining := []*types.GistFile{}
+	for _, file := range files {
+		if file.Name != name {
+			remaining = append(remaining, file)
+		}
+	}
+	if len(remaining) == len(files) {
+		return sql.ErrNoRows
+	}
+	if err := check(remaining); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM gist_file WHERE gist_id = $1 AND name = $2", gist_id, name)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	if err := createRevision(tx, gist_id, author_id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreGistRevision puts the gist back as it was at the revision, which makes a new revision.
+// It returns sql.ErrNoRows if the gist doesn't exist anymore.
+func (db *PgDatabase) RestoreGistRevision(revision *types.GistRevision, author_id string) (*types.Gist, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var restored_gist types.Gist
+	err = tx.Get(&restored_gist, "UPDATE gist SET name = $1 WHERE gist_id = $2 RETURNING *", revision.Name, revision.GistID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM gist_file WHERE gist_id = $1", revision.GistID); err != nil {
+		return nil, err
+	}
+	restored_gist.Files = []*types.GistFile{}
+	for _, file := range revision.Files {
+		var restored_file types.GistFile
+		err = tx.Get(&restored_file, "INSERT INTO gist_file (gist_id, name, content) VALUES ($1, $2, $3) RETURNING *", revision.GistID, file.Name, file.Content)
+		if err != nil {
+			return nil, err
+		}
+		restored_gist.Files = append(restored_gist.Files, &restored_file)
+	}
+	if err := touchGist(tx, &restored_gist, author_id); err != nil {
+		return nil, err
+	}
+	return &restored_gist, tx.Commit()
+}
+
+// GetGistRevisions lists the revisions of the gist without their files, the latest first
+func (db *PgDatabase) GetGistRevisions(gist_id string, limit int, offset int) ([]*types.GistRevision, error) {
+	revisions := []*types.GistRevision{}
+	err := db.db.Select(&revisions, "SELECT * FROM gist_revision WHERE gist_id = $1 ORDER BY number DESC LIMIT $2 OFFSET $3", gist_id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetGistRevision returns the revision of the gist along with its files, sorted by name
+func (db *PgDatabase) GetGistRevision(gist_id string, number int) (*types.GistRevision, error) {
+	var revision types.GistRevision
+	err := db.db.Get(&revision, "SELECT * FROM gist_revision WHERE gist_id = $1 AND number = $2", gist_id, number)
+	if err != nil {
+		return nil, err
+	}
+	revision.Files = []*types.GistFile{}
+	err = db.db.Select(&revision.Files, `SELECT gist_revision_file.name, gist_blob.content, gist_blob.size FROM gist_revision_file
+	JOIN gist_blob ON gist_blob.blob_id = gist_revision_file.blob_id
+	WHERE gist_revision_file.revision_id = $1 ORDER BY gist_revision_file.name`, revision.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// CreateOrganization creates the organization, with the user as its owner
+func (db *PgDatabase) CreateOrganization(organization *types.Organization, owner_id string) (*types.Organization, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var created_organization types.Organization
+	err = tx.Get(&created_organization, "INSERT INTO organization (name) VALUES ($1) RETURNING *", organization.Name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("INSERT INTO organization_member (organization_id, user_id, role) VALUES ($1, $2, $3)", created_organization.ID, owner_id, types.RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	created_organization.Role = types.RoleOwner
+	return &created_organization, tx.Commit()
+}
+
+func (db *PgDatabase) GetOrganizationByID(id string) (*types.Organization, error) {
+	var organization types.Organization
+	err := db.db.Get(&organization, "SELECT * FROM organization WHERE organization_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// GetOrganizationsByUserID lists the organizations the user is a member of, with their role
+func (db *PgDatabase) GetOrganizationsByUserID(user_id string) ([]*types.Organization, error) {
+	organizations := []*types.Organization{}
+	err := db.db.Select(&organizations, "SELECT organization.*, organization_member.role FROM organization JOIN organization_member ON organization_member.organization_id = organization.organization_id WHERE organization_member.user_id = $1 ORDER BY organization.name", user_id)
+	if err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// DeleteOrganization deletes the organization along with its members and gists. It returns sql.ErrNoRows if the organization doesn't exist.
+func (db *PgDatabase) DeleteOrganization(id string) error {
+	result, err := db.db.Exec("DELETE FROM organization WHERE organization_id = $1", id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetOrganizationMember returns sql.ErrNoRows if the user isn't a member of the organization
+func (db *PgDatabase) GetOrganizationMember(organization_id string, user_id string) (*types.OrganizationMember, error) {
+	var member types.OrganizationMember
+	err := db.db.Get(&member, "SELECT * FROM organization_member WHERE organization_id = $1 AND user_id = $2", organization_id, user_id)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (db *PgDatabase) GetOrganizationMembers(organization_id string) ([]*types.OrganizationMember, error) {
+	members := []*types.OrganizationMember{}
+	err := db.db.Select(&members, "SELECT * FROM organization_member WHERE organization_id = $1 ORDER BY created_at, user_id", organization_id)
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetOrganizationMember adds the member to the organization, or changes their role if they already are a member.
+// check is given the owners of the organization, locked until the transaction ends, and aborts the change with its error.
+func (db *PgDatabase) SetOrganizationMember(member *types.OrganizationMember, check func(owners []*types.OrganizationMember) error) (*types.OrganizationMember, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockOwners(tx, member.OrganizationID, check); err != nil {
+		return nil, err
+	}
+	var set_member types.OrganizationMember
+	err = tx.Get(&set_member, "INSERT INTO organization_member (organization_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role RETURNING *", member.OrganizationID, member.UserID, member.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &set_member, tx.Commit()
+}
+
+// DeleteOrganizationMember returns sql.ErrNoRows if the user isn't a member of the organization.
+// check is given the owners of the organization, locked until the transaction ends, and aborts the change with its error.
+func (db *PgDatabase) DeleteOrganizationMember(organization_id string, user_id string, check func(owners []*types.OrganizationMember) error) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOwners(tx, organization_id, check); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM organization_member WHERE organization_id = $1 AND user_id = $2", organization_id, user_id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// lockOwners locks the owners of the organization until the transaction ends and checks them, so that concurrent changes
+// of the members each see the owners the other left
+func lockOwners(tx *sqlx.Tx, organization_id string, check func(owners []*types.OrganizationMember) error) error {
+	owners := []*types.OrganizationMember{}
+	err := tx.Select(&owners, "SELECT * FROM organization_member WHERE organization_id = $1 AND role = $2 ORDER BY user_id FOR UPDATE", organization_id, types.RoleOwner)
+	if err != nil {
+		return err
+	}
+	return check(owners)
+}
+
+// lockGistFiles locks the gist until the transaction ends, so that concurrent edits see each other's files, and returns its files.
+// It returns sql.ErrNoRows if the gist doesn't exist.
+func lockGistFiles(tx *sqlx.Tx, gist_id string) ([]*types.GistFile, error) {
+	var locked_id string
+	if err := tx.Get(&locked_id, "SELECT gist_id FROM gist WHERE gist_id = $1 FOR UPDATE", gist_id); err != nil {
+		return nil, err
+	}
+	files := []*types.GistFile{}
+	if err := tx.Select(&files, "SELECT * FROM gist_file WHERE gist_id = $1 ORDER BY name", gist_id); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// touchGist records the edit of the gist as a new revision, reading the gist again for its new revision number
+func touchGist(tx *sqlx.Tx, gist *types.Gist, author_id string) error {
+	if err := createRevision(tx, gist.ID, author_id); err != nil {
+		return err
+	}
+	return tx.Get(gist, "SELECT * FROM gist WHERE gist_id = $1", gist.ID)
+}
+
+// createRevision records the gist as it is in the transaction as a new revision.
+// Only the contents the gist didn't have yet are stored, the files of the revision referring to the contents already stored otherwise.
+// Bumping the revision of the gist locks it until the transaction ends, so that concurrent edits get their own numbers.
+func createRevision(tx *sqlx.Tx, gist_id string, author_id string) error {
+	var revision_id string
+	err := tx.Get(&revision_id, `WITH bumped AS (
+		UPDATE gist SET revision = revision + 1, updated_at = NOW() WHERE gist_id = $1 RETURNING gist_id, revision, name
+	)
+	INSERT INTO gist_revision (gist_id, number, user_id, name) SELECT gist_id, revision, $2, name FROM bumped
+	RETURNING revision_id`, gist_id, author_id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO gist_blob (gist_id, digest, content)
+	SELECT gist_id, sha256(convert_to(content, 'UTF8')), content FROM gist_file WHERE gist_id = $1
+	ON CONFLICT (gist_id, digest) DO NOTHING`, gist_id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO gist_revision_file (revision_id, name, blob_id)
+	SELECT $1, gist_file.name, gist_blob.blob_id FROM gist_file
+	JOIN gist_blob ON gist_blob.gist_id = gist_file.gist_id AND gist_blob.digest = sha256(convert_to(gist_file.content, 'UTF8'))
+	WHERE gist_file.gist_id = $2`, revision_id, gist_id)
+	return err
+}
+
+// duplicate turns the violations of a unique constraint into ErrDuplicateName
+func duplicate(err error) error {
+	var pq_error *pq.Error
+	if errors.As(err, &pq_error) && pq_error.Code == "23505" {
+		return ErrDuplicateName
+	}
+	return err
+}
+
+var ErrDuplicateName error = errors.New("Name already taken")
@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gistsapp/api/types"
+)
+
+// Users resolves the users, who are managed by the auth service
+type Users interface {
+	GetUserByID(id string) (*types.User, error)
+}
+
+type authUsers struct {
+	url    string
+	client *http.Client
+}
+
+// NewAuthUsers asks the internal users endpoint of the auth service about the users.
+// client must authenticate the requests as a service granted the users:read scope.
+func NewAuthUsers(url string, client *http.Client) Users {
+	return authUsers{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+	}
+}
+
+// GetUserByID returns sql.ErrNoRows if the auth service doesn't know the user, like the database would
+func (a authUsers) GetUserByID(id string) (*types.User, error) {
+	response, err := a.client.Get(a.url + "/" + url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, sql.ErrNoRows
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrUsersUnavailable, response.Status)
+	}
+
+	var user types.User
+	if err := json.NewDecoder(response.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+var ErrUsersUnavailable error = errors.New("Can't resolve the user with the auth service")
@@ -0,0 +1,37 @@
+package types
+
+// a gist is a bundle of files a user shares, owned by the user who created it, or by an organization
+type Gist struct {
+	ID             string      `db:"gist_id" json:"id"`
+	UserID         string      `db:"user_id" json:"user_id"` // the owner, a types.User of the auth service, or the member who created it
+	OrganizationID *string     `db:"organization_id" json:"organization_id"`
+	Name           string      `db:"name" json:"name"`
+	CreatedAt      string      `db:"created_at" json:"created_at"`
+	UpdatedAt      string      `db:"updated_at" json:"updated_at"`
+	Revision       int         `db:"revision" json:"revision"` // number of the latest revision
+	Files          []*GistFile `db:"-" json:"files"`
+}
+
+// a gist file is named uniquely within its gist, its language being detected from its extension
+type GistFile struct {
+	ID        string `db:"gist_file_id" json:"-"`
+	GistID    string `db:"gist_id" json:"-"`
+	Name      string `db:"name" json:"name"`
+	Language  string `db:"-" json:"language"`
+	Content   string `db:"content" json:"content"`
+	Size      int    `db:"size" json:"size"` // in bytes
+	CreatedAt string `db:"created_at" json:"created_at"`
+	UpdatedAt string `db:"updated_at" json:"updated_at"`
+}
+
+// a revision is a copy of a gist as it was after one of its edits, which is never changed afterwards.
+// Revisions are numbered from 1, in the order of the edits.
+type GistRevision struct {
+	ID        string      `db:"revision_id" json:"-"`
+	GistID    string      `db:"gist_id" json:"gist_id"`
+	Number    int         `db:"number" json:"number"`
+	UserID    string      `db:"user_id" json:"user_id"` // who made the edit
+	Name      string      `db:"name" json:"name"`
+	CreatedAt string      `db:"created_at" json:"created_at"`
+	Files     []*GistFile `db:"-" json:"files,omitempty"`
+}
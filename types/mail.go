@@ -0,0 +1,32 @@
+package types
+
+const (
+	MailPending = "pending" // waiting for its next attempt
+	MailSending = "sending" // claimed by a worker, claimable again once its lease is over
+	MailDead    = "dead"    // gave up after too many attempts, or expired before it could be sent
+)
+
+// an outbox mail is a rendered email waiting to be delivered by the mail workers.
+// it is deleted once sent.
+type OutboxMail struct {
+	ID            string  `db:"mail_id" json:"id"`
+	Sender        string  `db:"sender" json:"sender"`
+	Recipient     string  `db:"recipient" json:"recipient"`
+	Subject       string  `db:"subject" json:"subject"`
+	HTML          string  `db:"html" json:"-"`
+	Text          string  `db:"text" json:"-"`
+	Status        string  `db:"status" json:"status"`
+	Attempts      int     `db:"attempts" json:"attempts"`
+	NextAttemptAt string  `db:"next_attempt_at" json:"next_attempt_at"`
+	LastError     *string `db:"last_error" json:"last_error"`
+	CreatedAt     string  `db:"created_at" json:"created_at"`
+	ExpiresAt     *string `db:"expires_at" json:"expires_at"` // no point in sending a login code once it expired
+}
+
+type OutboxStatus struct {
+	Pending     int           `db:"pending" json:"pending"`
+	Sending     int           `db:"sending" json:"sending"`
+	Dead        int           `db:"dead" json:"dead"`
+	OldestMail  *string       `db:"oldest_mail" json:"oldest_mail"` // creation date of the oldest mail waiting to be sent
+	DeadLetters []*OutboxMail `db:"-" json:"dead_letters"`
+}
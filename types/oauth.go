@@ -0,0 +1,26 @@
+package types
+
+// an OAuth client is one of our apps getting tokens from the auth service.
+// its lists are space separated, like OAuth scopes.
+type OAuthClient struct {
+	ID           string  `db:"client_id"`
+	Secret       *string `db:"secret"` // hash of the secret, public clients can't keep one and have none
+	Name         string  `db:"name"`
+	RedirectURIs string  `db:"redirect_uris"`
+	GrantTypes   string  `db:"grant_types"`
+	Scopes       string  `db:"scopes"` // the scopes the client may ask for
+	CreatedAt    string  `db:"created_at"`
+}
+
+// an authorization code is handed to a client once the user logged in, to be exchanged for tokens once
+type AuthorizationCode struct {
+	Code                string  `db:"code"` // hash of the code
+	ClientID            string  `db:"client_id"`
+	UserID              string  `db:"user_id"`
+	FederatedIdentityID string  `db:"federated_identity_id"` // the identity of the session the user authorized from
+	RedirectURI         string  `db:"redirect_uri"`
+	Scope               string  `db:"scope"`
+	CodeChallenge       string  `db:"code_challenge"` // S256 PKCE challenge, the client proves it started the request with its verifier
+	Nonce               *string `db:"nonce"`
+	ExpiresAt           string  `db:"expires_at"`
+}
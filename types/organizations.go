@@ -0,0 +1,23 @@
+package types
+
+// roles of the members of an organization, from the most to the least powerful
+const (
+	RoleOwner  = "owner"  // manages the organization and its members, and deletes its gists
+	RoleEditor = "editor" // creates and edits the gists of the organization
+	RoleViewer = "viewer" // reads the gists of the organization
+)
+
+// an organization owns gists shared by its members
+type Organization struct {
+	ID        string `db:"organization_id" json:"id"`
+	Name      string `db:"name" json:"name"`
+	CreatedAt string `db:"created_at" json:"created_at"`
+	Role      string `db:"role" json:"role,omitempty"` // the role of the user the organization was listed for
+}
+
+type OrganizationMember struct {
+	OrganizationID string `db:"organization_id" json:"-"`
+	UserID         string `db:"user_id" json:"user_id"` // a types.User of the auth service
+	Role           string `db:"role" json:"role"`
+	CreatedAt      string `db:"created_at" json:"created_at"`
+}
@@ -19,10 +19,31 @@ type FederatedIdentity struct {
 // an opaque token is a token that tied to a user and stored in the database.
 // it is used as a refresh token for example.
 type OpaqueToken struct {
-	ID       string `db:"token_id"`
-	UserID   string `db:"user_id"`
-	Token    string `db:"token"`
-	ExpiresAt string `db:"expires_at"`
+	ID         string  `db:"token_id"`
+	UserID     string  `db:"user_id"`
+	Token      string  `db:"token"`
+	ExpiresAt  string  `db:"expires_at"`
+	FamilyID   string  `db:"family_id"`  // every token descending from the same login shares its family
+	RotatedAt  *string `db:"rotated_at"` // set once the token was exchanged for its successor
+	CreatedAt  string  `db:"created_at"` // start of the session, carried over on rotation
+	LastUsedAt *string `db:"last_used_at"`
+	UserAgent  string  `db:"user_agent"`
+	IP         string  `db:"ip"`
+	// the identity the session was opened with
+	FederatedIdentityID *string `db:"federated_identity_id"`
+	// the OAuth client the session was opened by, which alone can renew it, with the scope granted to it
+	ClientID *string `db:"client_id"`
+	Scope    *string `db:"scope"`
+}
+
+// a session is a login on a device, backed by a refresh token family
+type Session struct {
+	ID         string  `json:"id"`
+	CreatedAt  string  `json:"created_at"`
+	LastUsedAt *string `json:"last_used_at"`
+	UserAgent  string  `json:"user_agent"`
+	IP         string  `json:"ip"`
+	Current    bool    `json:"current"`
 }
 
 type AuthTokens struct {
@@ -31,11 +52,34 @@ type AuthTokens struct {
 }
 
 type JWTClaims struct {
-	UserID string `json:"user_id"`
+	UserID    string   `json:"user_id"`
+	SessionID string   `json:"sid,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"` // the OAuth client the token was issued to, none for our frontend
+	Scope     string   `json:"scope,omitempty"`     // space separated scopes granted to the client
 	jwt.RegisteredClaims
 }
 
 type VerificationToken struct {
-	Email    string `db:"email"`
-	Token    string `db:"token"`
+	Email     string `db:"email"`
+	Token     string `db:"token"`
+	CreatedAt string `db:"created_at"`
+	ExpiresAt string `db:"expires_at"`
+	Attempts  int    `db:"attempts"` // failed attempts, the token is burnt after too many of them
+	// the magic link sent along with the code, bound to the browser that asked for it by a nonce
+	LinkToken     *string `db:"link_token"`
+	LinkNonce     *string `db:"link_nonce"`
+	LinkExpiresAt *string `db:"link_expires_at"`
+}
+
+// a personal access token lets a user call the API from their scripts, with the scopes they chose
+type PersonalAccessToken struct {
+	ID         string  `db:"pat_id" json:"id"`
+	UserID     string  `db:"user_id" json:"-"`
+	Name       string  `db:"name" json:"name"`
+	Token      string  `db:"token" json:"-"` // hash of the token, which is only shown once
+	Scope      string  `db:"scope" json:"scope"`
+	CreatedAt  string  `db:"created_at" json:"created_at"`
+	ExpiresAt  string  `db:"expires_at" json:"expires_at"`
+	LastUsedAt *string `db:"last_used_at" json:"last_used_at"`
 }
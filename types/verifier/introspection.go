@@ -0,0 +1,55 @@
+package verifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gistsapp/api/types"
+)
+
+// Introspection is the answer of the introspection endpoint of the auth service.
+// The claims are only given for active tokens.
+type Introspection struct {
+	Active bool `json:"active"`
+	*types.JWTClaims
+}
+
+type introspectionVerifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewIntrospectionVerifier asks the auth service about the tokens that can't be verified with its keys alone, like personal access tokens.
+// client must authenticate the requests as a service granted the tokens:introspect scope.
+func NewIntrospectionVerifier(url string, client *http.Client) Verifier {
+	return introspectionVerifier{
+		url:    url,
+		client: client,
+	}
+}
+
+func (i introspectionVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	response, err := i.client.PostForm(i.url, url.Values{"token": {token}})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrIntrospectionUnavailable, response.Status)
+	}
+
+	var introspection Introspection
+	if err := json.NewDecoder(response.Body).Decode(&introspection); err != nil {
+		return nil, err
+	}
+	if !introspection.Active || introspection.JWTClaims == nil {
+		return nil, ErrInactiveToken
+	}
+	return introspection.JWTClaims, nil
+}
+
+var ErrIntrospectionUnavailable error = errors.New("Can't introspect the token with the auth service")
+var ErrInactiveToken error = errors.New("Invalid or expired token")
@@ -0,0 +1,77 @@
+package verifier
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gistsapp/api/types"
+)
+
+// the keys are fetched again when a token is signed with an unknown key, at most this often
+const jwksRefreshInterval = time.Minute
+
+// remoteKeySource reads the public keys the auth service publishes on /.well-known/jwks.json,
+// so that the other services don't hold any secret
+type remoteKeySource struct {
+	url       string
+	client    *http.Client
+	mutex     sync.Mutex
+	jwks      types.JWKS
+	fetchedAt time.Time
+}
+
+func NewRemoteKeySource(url string) KeySource {
+	return &remoteKeySource{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PublicKey returns the key identified by kid, fetching the keys again if it's unknown, for the auth service may have rotated them
+func (r *remoteKeySource) PublicKey(kid string) (crypto.PublicKey, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key, err := r.jwks.Key(kid)
+	if err != nil && time.Since(r.fetchedAt) >= jwksRefreshInterval {
+		if err := r.fetch(); err != nil {
+			return nil, "", err
+		}
+		key, err = r.jwks.Key(kid)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	public_key, err := key.PublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	return public_key, key.Algorithm, nil
+}
+
+func (r *remoteKeySource) fetch() error {
+	r.fetchedAt = time.Now()
+	response, err := r.client.Get(r.url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrJWKSUnavailable, response.Status)
+	}
+
+	var jwks types.JWKS
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		return err
+	}
+	r.jwks = jwks
+	return nil
+}
+
+var ErrJWKSUnavailable error = errors.New("Can't fetch the keys of the auth service")
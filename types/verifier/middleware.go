@@ -0,0 +1,79 @@
+package verifier
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultCookie is the name of the cookie holding the access token, unless renamed through cookies.auth in the config
+const DefaultCookie = "access_token"
+
+// Cookies tells which cookie holds the access token, as set in the cookies.auth section of the config.
+// The auth service reads that section into it too, so that every service agrees on the name.
+type Cookies struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	AccessToken string `mapstructure:"access_token"`
+}
+
+// Name returns the name of the cookie holding the access token
+func (c Cookies) Name() string {
+	if c.Enabled {
+		return c.AccessToken
+	}
+	return DefaultCookie
+}
+
+// AccessToken reads the token from the Authorization header, or from the cookie when there is no bearer token
+func AccessToken(c *fiber.Ctx, cookie string) string {
+	if token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer "); found {
+		return token
+	}
+	return c.Cookies(cookie)
+}
+
+// Middleware authenticates users with the token found by AccessToken.
+// It sets the userID, sessionID, access_token and claims locals, and the scope for the tokens that are Scoped, for RequireScopes.
+func Middleware(verifier Verifier, cookie string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := AccessToken(c, cookie)
+		if token == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or malformed JWT"})
+		}
+
+		claims, err := verifier.VerifyAccessToken(token)
+		// tokens issued to OAuth clients themselves act for no user
+		if err != nil || claims.UserID == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired JWT"})
+		}
+
+		c.Locals("userID", claims.UserID)
+		c.Locals("sessionID", claims.SessionID)
+		c.Locals("access_token", token)
+		c.Locals("claims", claims)
+		if Scoped(claims) {
+			c.Locals("scope", claims.Scope)
+		}
+		return c.Next()
+	}
+}
+
+// RequireScopes rejects the requests whose token wasn't granted every one of the scopes.
+// The tokens that aren't limited to a scope always get through.
+func RequireScopes(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scope, scoped := c.Locals("scope").(string)
+		if !scoped {
+			return c.Next()
+		}
+		granted := strings.Fields(scope)
+		for _, required := range scopes {
+			if !slices.Contains(granted, required) {
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Missing scope " + required})
+			}
+		}
+		return c.Next()
+	}
+}
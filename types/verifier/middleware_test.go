@@ -0,0 +1,102 @@
+package verifier
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gistsapp/api/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// claimsVerifier accepts the tokens it knows, with their claims
+type claimsVerifier map[string]*types.JWTClaims
+
+func (v claimsVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	return claims, nil
+}
+
+func TestRequireScopes(t *testing.T) {
+	tokens := claimsVerifier{
+		"session":        {UserID: "user", SessionID: "session"},
+		"client":         {UserID: "user", SessionID: "client-session", ClientID: "client", Scope: "openid gists:read gists:write"},
+		"client-read":    {UserID: "user", SessionID: "client-session", ClientID: "client", Scope: "openid gists:read"},
+		"personal":       {UserID: "user", Scope: "gists:write gists:read"},
+		"personal-empty": {UserID: "user"},
+		"service":        {ClientID: "service", Scope: "gists:read gists:write"},
+	}
+	app := fiber.New()
+	app.Post("/gists", Middleware(tokens, DefaultCookie), RequireScopes("gists:read", "gists:write"), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{token: "session", status: fiber.StatusOK},
+		{token: "client", status: fiber.StatusOK},
+		{token: "client-read", status: fiber.StatusForbidden},
+		{token: "personal", status: fiber.StatusOK},
+		{token: "personal-empty", status: fiber.StatusForbidden},
+		{token: "service", status: fiber.StatusUnauthorized}, // acts for no user
+		{token: "unknown", status: fiber.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			request := httptest.NewRequest(fiber.MethodPost, "/gists", nil)
+			request.Header.Set(fiber.HeaderAuthorization, "Bearer "+test.token)
+			response, err := app.Test(request)
+			require.NoError(t, err)
+			assert.Equal(t, test.status, response.StatusCode)
+			if test.status == fiber.StatusForbidden {
+				assert.Equal(t, `Bearer error="insufficient_scope", scope="gists:read gists:write"`, response.Header.Get(fiber.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		token         string
+	}{
+		{name: "bearer", authorization: "Bearer header", token: "header"},
+		{name: "cookie", cookie: "cookie", token: "cookie"},
+		{name: "bearer first", authorization: "Bearer header", cookie: "cookie", token: "header"},
+		{name: "other scheme", authorization: "Basic credentials", cookie: "cookie", token: "cookie"},
+		{name: "nothing", token: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(AccessToken(c, "session_token"))
+			})
+			request := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if test.authorization != "" {
+				request.Header.Set(fiber.HeaderAuthorization, test.authorization)
+			}
+			if test.cookie != "" {
+				request.Header.Set(fiber.HeaderCookie, "session_token="+test.cookie)
+			}
+			response, err := app.Test(request)
+			require.NoError(t, err)
+			body := make([]byte, 64)
+			n, _ := response.Body.Read(body)
+			assert.Equal(t, test.token, string(body[:n]))
+		})
+	}
+}
+
+func TestCookiesName(t *testing.T) {
+	assert.Equal(t, DefaultCookie, Cookies{AccessToken: "gists_access_token"}.Name())
+	assert.Equal(t, "gists_access_token", Cookies{Enabled: true, AccessToken: "gists_access_token"}.Name())
+}
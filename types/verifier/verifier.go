@@ -0,0 +1,127 @@
+// Package verifier authenticates the requests made to our services with the tokens issued by the auth service.
+// The auth service verifies them with its own keyring, the other services with the keys it publishes.
+package verifier
+
+import (
+	"crypto"
+	"errors"
+	"strings"
+
+	"github.com/gistsapp/api/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PersonalAccessTokenPrefix tells personal access tokens apart from JWTs, and makes them easy to spot when they leak
+const PersonalAccessTokenPrefix = "gpat_"
+
+// Verifier checks a token, returning its claims
+type Verifier interface {
+	VerifyAccessToken(token string) (*types.JWTClaims, error)
+}
+
+// KeySource gives the public key identified by kid, along with the algorithm it signs with
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, string, error)
+}
+
+// Parse checks the signature, the expiration and the issuer of an access token, returning its claims.
+// The token must also be meant for audience, unless it is empty: ID tokens, which the auth service signs with the same keys,
+// have another issuer, and the tokens meant for other services another audience.
+func Parse(tokenString string, keys KeySource, issuer string, audience string) (*types.JWTClaims, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"RS256", "EdDSA"}), jwt.WithIssuer(issuer)}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &types.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, algorithm, err := keys.PublicKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if algorithm != "" && token.Method.Alg() != algorithm {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*types.JWTClaims)
+	if !ok || !token.Valid || claims.IssuedAt == nil {
+		return nil, jwt.ErrTokenMalformed
+	}
+	return claims, nil
+}
+
+type keyVerifier struct {
+	keys     KeySource
+	issuer   string
+	audience string
+}
+
+// New verifies the access tokens issued by issuer for audience with the keys only, revoked tokens being accepted until they expire
+func New(keys KeySource, issuer string, audience string) Verifier {
+	return keyVerifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+func (k keyVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	return Parse(token, k.keys, k.issuer, k.audience)
+}
+
+type introspectedVerifier struct {
+	accessTokens  Verifier
+	introspection Verifier
+}
+
+// WithIntrospection checks the access tokens with accessTokens, then asks introspection whether the valid ones are still active,
+// so that the tokens revoked before they expire are rejected
+func WithIntrospection(accessTokens Verifier, introspection Verifier) Verifier {
+	return introspectedVerifier{
+		accessTokens:  accessTokens,
+		introspection: introspection,
+	}
+}
+
+func (i introspectedVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	if _, err := i.accessTokens.VerifyAccessToken(token); err != nil {
+		return nil, err
+	}
+	return i.introspection.VerifyAccessToken(token)
+}
+
+type personalAccessTokenVerifier struct {
+	accessTokens         Verifier
+	personalAccessTokens Verifier
+}
+
+// WithPersonalAccessTokens hands the personal access tokens to their own verifier, the other tokens to accessTokens.
+// Without a verifier for them, personal access tokens are rejected.
+func WithPersonalAccessTokens(accessTokens Verifier, personalAccessTokens Verifier) Verifier {
+	return personalAccessTokenVerifier{
+		accessTokens:         accessTokens,
+		personalAccessTokens: personalAccessTokens,
+	}
+}
+
+func (p personalAccessTokenVerifier) VerifyAccessToken(token string) (*types.JWTClaims, error) {
+	if strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+		if p.personalAccessTokens == nil {
+			return nil, ErrPersonalAccessTokensUnsupported
+		}
+		return p.personalAccessTokens.VerifyAccessToken(token)
+	}
+	return p.accessTokens.VerifyAccessToken(token)
+}
+
+// Scoped tells if the token is limited to its scope: the tokens issued to OAuth clients and the personal access tokens are,
+// while the tokens of our own apps, which are tied to a login session, can do anything their user can
+func Scoped(claims *types.JWTClaims) bool {
+	return claims.ClientID != "" || claims.SessionID == ""
+}
+
+var ErrPersonalAccessTokensUnsupported error = errors.New("Personal access tokens aren't accepted here")
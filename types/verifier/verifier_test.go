@@ -0,0 +1,370 @@
+package verifier
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gistsapp/api/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey signs tokens with an Ed25519 key, as the auth service does
+type testKey struct {
+	kid     string
+	private ed25519.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return &testKey{kid: kid, private: private}
+}
+
+func (k *testKey) JWK() types.JWK {
+	return types.JWK{
+		KeyType:   "OKP",
+		KeyID:     k.kid,
+		Use:       "sig",
+		Algorithm: "EdDSA",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+	}
+}
+
+func (k *testKey) sign(t *testing.T, claims *types.JWTClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.private)
+	require.NoError(t, err)
+	return signed
+}
+
+// keyMap is a KeySource holding its keys in memory
+type keyMap map[string]types.JWK
+
+func (m keyMap) PublicKey(kid string) (crypto.PublicKey, string, error) {
+	key, ok := m[kid]
+	if !ok {
+		return nil, "", types.ErrNotFound
+	}
+	public_key, err := key.PublicKey()
+	return public_key, key.Algorithm, err
+}
+
+// claimsFor returns the claims of a token of the gists issuer, meant for audience, valid for an hour
+func claimsFor(audience ...string) *types.JWTClaims {
+	now := time.Now()
+	return &types.JWTClaims{
+		UserID:    "user",
+		SessionID: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "gists",
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	key := newTestKey(t, "key")
+	other := newTestKey(t, "other")
+	keys := keyMap{"key": key.JWK()}
+
+	rsa_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	// a key published for RS256 can't verify a token that claims another algorithm
+	keys["rsa"] = types.JWK{
+		KeyType:   "RSA",
+		KeyID:     "rsa",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(rsa_key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+	}
+	rs256 := jwt.NewWithClaims(jwt.SigningMethodRS256, claimsFor("gists"))
+	rs256.Header["kid"] = "rsa"
+	rs256_token, err := rs256.SignedString(rsa_key)
+	require.NoError(t, err)
+	ps256 := jwt.NewWithClaims(jwt.SigningMethodPS256, claimsFor("gists"))
+	ps256.Header["kid"] = "rsa"
+	ps256_token, err := ps256.SignedString(rsa_key)
+	require.NoError(t, err)
+	hs256 := jwt.NewWithClaims(jwt.SigningMethodHS256, claimsFor("gists"))
+	hs256.Header["kid"] = "key"
+	hs256_token, err := hs256.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claimsFor("gists"))
+	none.Header["kid"] = "key"
+	none_token, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	expired := claimsFor("gists")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	other_issuer := claimsFor("gists")
+	other_issuer.Issuer = "https://accounts.example.com"
+	no_issued_at := claimsFor("gists")
+	no_issued_at.IssuedAt = nil
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		valid    bool
+	}{
+		{name: "valid", token: key.sign(t, claimsFor("gists")), audience: "gists", valid: true},
+		{name: "one of the audiences", token: key.sign(t, claimsFor("web", "gists")), audience: "gists", valid: true},
+		{name: "any audience", token: key.sign(t, claimsFor("web")), audience: "", valid: true},
+		{name: "RS256", token: rs256_token, audience: "gists", valid: true},
+		{name: "other audience", token: key.sign(t, claimsFor("web")), audience: "gists"},
+		{name: "no audience", token: key.sign(t, claimsFor()), audience: "gists"},
+		{name: "other issuer", token: key.sign(t, other_issuer), audience: "gists"},
+		{name: "expired", token: key.sign(t, expired), audience: "gists"},
+		{name: "no issued at", token: key.sign(t, no_issued_at), audience: "gists"},
+		{name: "unknown key", token: other.sign(t, claimsFor("gists")), audience: "gists"},
+		{name: "algorithm of another key", token: ps256_token, audience: "gists"},
+		{name: "HS256", token: hs256_token, audience: "gists"},
+		{name: "none", token: none_token, audience: "gists"},
+		{name: "malformed", token: "not a token", audience: "gists"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := Parse(test.token, keys, "gists", test.audience)
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user", claims.UserID)
+			assert.Equal(t, "session", claims.SessionID)
+		})
+	}
+}
+
+// jwksServer publishes keys like the auth service does, counting how often they are fetched
+type jwksServer struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	keys    []types.JWK
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...types.JWK) *jwksServer {
+	server := &jwksServer{keys: keys}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.fetches++
+		json.NewEncoder(w).Encode(types.JWKS{Keys: server.keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *jwksServer) publish(key types.JWK) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = append(s.keys, key)
+}
+
+func TestRemoteKeySourceRefetchesUnknownKeys(t *testing.T) {
+	current := newTestKey(t, "current")
+	rotated := newTestKey(t, "rotated")
+	server := newJWKSServer(t, current.JWK())
+	keys := NewRemoteKeySource(server.URL)
+	verifier := New(keys, "gists", "gists")
+
+	_, err := verifier.VerifyAccessToken(current.sign(t, claimsFor("gists")))
+	require.NoError(t, err)
+	_, err = verifier.VerifyAccessToken(current.sign(t, claimsFor("gists")))
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.fetches, "the keys are cached")
+
+	// the auth service rotates its keys: the new one is only looked for once the refresh interval is over
+	server.publish(rotated.JWK())
+	token := rotated.sign(t, claimsFor("gists"))
+	_, err = verifier.VerifyAccessToken(token)
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.fetches)
+
+	keys.(*remoteKeySource).fetchedAt = time.Now().Add(-jwksRefreshInterval)
+	_, err = verifier.VerifyAccessToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.fetches)
+
+	// a token signed with a key that was never published doesn't make every request fetch the keys
+	keys.(*remoteKeySource).fetchedAt = time.Now().Add(-jwksRefreshInterval)
+	unknown := newTestKey(t, "unknown")
+	for range 3 {
+		_, err = verifier.VerifyAccessToken(unknown.sign(t, claimsFor("gists")))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 3, server.fetches)
+}
+
+func TestRemoteKeySourceUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, _, err := NewRemoteKeySource(server.URL).PublicKey("key")
+	assert.ErrorIs(t, err, ErrJWKSUnavailable)
+}
+
+// introspectionServer answers like the introspection endpoint of the auth service, for the tokens it knows
+type introspectionServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	tokens   map[string]*types.JWTClaims // the active tokens
+	requests int
+}
+
+func newIntrospectionServer(t *testing.T, tokens map[string]*types.JWTClaims) *introspectionServer {
+	server := &introspectionServer{tokens: tokens}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.requests++
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		claims, active := server.tokens[r.PostFormValue("token")]
+		json.NewEncoder(w).Encode(Introspection{Active: active, JWTClaims: claims})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWithIntrospection(t *testing.T) {
+	key := newTestKey(t, "key")
+	forged := newTestKey(t, "key") // same kid, other key
+	active := key.sign(t, claimsFor("gists"))
+	revoked_claims := claimsFor("gists")
+	revoked_claims.ID = "revoked"
+	revoked := key.sign(t, revoked_claims)
+	introspected := claimsFor("gists")
+	introspected.Roles = []string{"admin"}
+	server := newIntrospectionServer(t, map[string]*types.JWTClaims{active: introspected})
+	verifier := WithIntrospection(New(keyMap{"key": key.JWK()}, "gists", "gists"), NewIntrospectionVerifier(server.URL, server.Client()))
+
+	tests := []struct {
+		name       string
+		token      string
+		err        error
+		introspect bool
+	}{
+		{name: "active", token: active, introspect: true},
+		{name: "revoked", token: revoked, err: ErrInactiveToken, introspect: true},
+		{name: "forged", token: forged.sign(t, claimsFor("gists"))},
+		{name: "other audience", token: key.sign(t, claimsFor("web"))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := server.requests
+			claims, err := verifier.VerifyAccessToken(test.token)
+			switch {
+			case test.err != nil:
+				assert.ErrorIs(t, err, test.err)
+			case test.introspect:
+				require.NoError(t, err)
+				assert.Equal(t, []string{"admin"}, claims.Roles, "the claims are the ones of the auth service")
+			default:
+				assert.Error(t, err)
+			}
+			if test.introspect {
+				assert.Equal(t, requests+1, server.requests)
+			} else {
+				assert.Equal(t, requests, server.requests, "the tokens the keys reject aren't sent to the auth service")
+			}
+		})
+	}
+}
+
+func TestIntrospectionUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	_, err := NewIntrospectionVerifier(server.URL, server.Client()).VerifyAccessToken("token")
+	assert.ErrorIs(t, err, ErrIntrospectionUnavailable)
+}
+
+func TestWithPersonalAccessTokens(t *testing.T) {
+	key := newTestKey(t, "key")
+	access_token := key.sign(t, claimsFor("gists"))
+	personal := &types.JWTClaims{UserID: "user", Scope: "gists:read"}
+	server := newIntrospectionServer(t, map[string]*types.JWTClaims{
+		PersonalAccessTokenPrefix + "active": personal,
+		access_token:                         claimsFor("gists"),
+	})
+	introspection := NewIntrospectionVerifier(server.URL, server.Client())
+	access_tokens := New(keyMap{"key": key.JWK()}, "gists", "gists")
+
+	tests := []struct {
+		name      string
+		verifier  Verifier
+		token     string
+		err       error
+		personal  bool
+		requested bool
+	}{
+		{name: "personal access token", verifier: WithPersonalAccessTokens(access_tokens, introspection), token: PersonalAccessTokenPrefix + "active", personal: true, requested: true},
+		{name: "revoked personal access token", verifier: WithPersonalAccessTokens(access_tokens, introspection), token: PersonalAccessTokenPrefix + "revoked", err: ErrInactiveToken, requested: true},
+		{name: "access token", verifier: WithPersonalAccessTokens(access_tokens, introspection), token: access_token},
+		{name: "unsupported", verifier: WithPersonalAccessTokens(access_tokens, nil), token: PersonalAccessTokenPrefix + "active", err: ErrPersonalAccessTokensUnsupported},
+		{name: "access token without personal access tokens", verifier: WithPersonalAccessTokens(access_tokens, nil), token: access_token},
+		{name: "unknown prefix", verifier: WithPersonalAccessTokens(access_tokens, introspection), token: "pat_active", err: jwt.ErrTokenMalformed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := server.requests
+			claims, err := test.verifier.VerifyAccessToken(test.token)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "user", claims.UserID)
+				assert.Equal(t, test.personal, claims.SessionID == "")
+			}
+			if test.requested {
+				assert.Equal(t, requests+1, server.requests)
+			} else {
+				assert.Equal(t, requests, server.requests, "the access tokens are verified with the keys")
+			}
+		})
+	}
+}
+
+func TestScoped(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *types.JWTClaims
+		scoped bool
+	}{
+		{name: "login session", claims: &types.JWTClaims{UserID: "user", SessionID: "session"}},
+		{name: "login session with a scope", claims: &types.JWTClaims{UserID: "user", SessionID: "session", Scope: "gists:read"}},
+		{name: "OAuth client", claims: &types.JWTClaims{UserID: "user", SessionID: "session", ClientID: "client", Scope: "openid"}, scoped: true},
+		{name: "OAuth client without scope", claims: &types.JWTClaims{UserID: "user", SessionID: "session", ClientID: "client"}, scoped: true},
+		{name: "personal access token", claims: &types.JWTClaims{UserID: "user", Scope: "gists:read"}, scoped: true},
+		{name: "service", claims: &types.JWTClaims{ClientID: "service", Scope: "users:read"}, scoped: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.scoped, Scoped(test.claims))
+		})
+	}
+}